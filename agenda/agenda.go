@@ -2,259 +2,380 @@ package agenda
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/nheuillet/calendar-linker/intra"
 	"github.com/nheuillet/calendar-linker/parser"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/calendar/v3"
 )
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
-	}
-	return config.Client(context.Background(), tok)
-}
+func getAttendees(config *parser.Config, ev *intra.Activity) []Attendee {
+	var attendees []Attendee
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+	if !config.ProjectParticipant {
+		return nil
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+	for index, at := range ev.Participants {
+		attendees = append(attendees, Attendee{
+			Email: at,
+			Name:  ev.ParticipantsName[index],
+		})
 	}
-	return tok
+	return attendees
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
+// Keys of the private properties set on every event created by the linker.
+// They identify the intra activity an event comes from, whatever the user does to its summary or description.
+const (
+	propertyLinker   = "calendarLinker"
+	propertyKind     = "kind"
+	propertyModule   = "codeModule"
+	propertyInstance = "codeInstance"
+	propertyActi     = "codeActi"
+	propertyEvent    = "codeEvent"
+	propertyYear     = "scolarYear"
+)
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	err = json.NewEncoder(f).Encode(token)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+const linkerMarker = "1"
+
+// kinds of events created by the linker
+const (
+	kindClass   = "class"
+	kindProject = "project"
+)
+
+// linkerDescription matches the description written on the class events by the versions of the linker
+// that did not set extended properties: the acti code. Those events are adopted on the next synchronisation.
+var linkerDescription = regexp.MustCompile(`^acti-\d+$`)
+
+func classProperties(ev intra.Event) map[string]string {
+	return map[string]string{
+		propertyLinker:   linkerMarker,
+		propertyKind:     kindClass,
+		propertyModule:   ev.CodeModule,
+		propertyInstance: ev.CodeInstance,
+		propertyActi:     ev.CodeActi,
+		propertyEvent:    ev.CodeEvent,
+		propertyYear:     ev.ScolarYear,
 	}
 }
 
-//GetEvents lists the next 250 events in the calendar
-func GetEvents(srv *calendar.Service, agenda string) *calendar.Events {
-
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List(agenda).ShowDeleted(false).
-		SingleEvents(true).TimeMin(t).MaxResults(250).OrderBy("startTime").Do()
-	if err != nil {
-		log.Printf("Unable to retrieve next ten of the user's events: %v\n", err)
+func projectProperties(ev intra.Activity) map[string]string {
+	return map[string]string{
+		propertyLinker:   linkerMarker,
+		propertyKind:     kindProject,
+		propertyModule:   ev.CodeModule,
+		propertyInstance: ev.CodeInstance,
+		propertyActi:     ev.CodeActi,
+		propertyYear:     ev.ScolarYear,
 	}
-	return events
 }
 
-// parseTime is a very ugly piece of code because Epitech returned time is not golang time compliant (unless i'm an idiot)
-// I'm using time.now().format(rfc.RFC3339 to get the local timezone), while
-// building manually the rfc3339 timestamp. It's ugly and I'm ashamed to do it but it works for now.....
-func parseTime(timeStr string) string {
-	tmpTime := time.Now().Format(time.RFC3339)
+// eventIdentity builds a string uniquely identifying the intra activity described by the properties.
+func eventIdentity(properties map[string]string) string {
+	return strings.Join([]string{
+		properties[propertyKind],
+		properties[propertyYear],
+		properties[propertyModule],
+		properties[propertyInstance],
+		properties[propertyActi],
+		properties[propertyEvent],
+	}, "/")
+}
 
-	timeStr = strings.Replace(timeStr, " ", "T", 1)
-	tmpPieceTimezone := strings.Split(tmpTime, ":")[2:]
-	timeStr += tmpPieceTimezone[0][2:] + ":" + tmpPieceTimezone[1]
+// normalizeNewlines turns the CRLF and CR line endings of the intra texts into LF, as the calendars
+// return them: iCalendar escapes every line ending as \n for example.
+func normalizeNewlines(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	return strings.Replace(text, "\r", "\n", -1)
+}
 
-	return timeStr
+// eventChanged checks if the calendar event needs to be updated to match the wanted one.
+func eventChanged(current *Event, wanted *Event) bool {
+	return current.Summary != wanted.Summary ||
+		current.Location != wanted.Location ||
+		normalizeNewlines(current.Description) != normalizeNewlines(wanted.Description) ||
+		!current.Start.Equal(wanted.Start) ||
+		!current.End.Equal(wanted.End)
 }
 
-func getTime(ev intra.Event) (string, string) {
-	var start string
-	end := parseTime(ev.End)
+// pairEvents matches the events of the intra with the legacy calendar events sharing the same acti code.
+// Events starting at the same time are paired first, the remaining ones are paired in chronological order
+// so that a moved session updates its former calendar event. Unpaired intra events have a nil calendar event.
+// The calendar events left unpaired are returned as well.
+func pairEvents(events []*Event, calEvents []*Event) ([]*Event, []*Event) {
+	pairs := make([]*Event, len(events))
+	used := make([]bool, len(calEvents))
 
-	if ev.RdvGroupRegistered != "" {
-		start = parseTime(strings.Split(ev.RdvGroupRegistered, "|")[0])
-		end = parseTime(strings.Split(ev.RdvGroupRegistered, "|")[1])
-	} else if ev.RdvIndivRegistered != "" {
-		start = parseTime(strings.Split(ev.RdvIndivRegistered, "|")[0])
-		end = parseTime(strings.Split(ev.RdvIndivRegistered, "|")[1])
-	} else {
-		start = parseTime(ev.Start)
+	for i, ev := range events {
+		for j, cEv := range calEvents {
+			if !used[j] && cEv.Start.Equal(ev.Start) {
+				pairs[i] = cEv
+				used[j] = true
+				break
+			}
+		}
 	}
-
-	return start, end
+	j := 0
+	for i := range events {
+		if pairs[i] != nil {
+			continue
+		}
+		for j < len(calEvents) && used[j] {
+			j++
+		}
+		if j == len(calEvents) {
+			break
+		}
+		pairs[i] = calEvents[j]
+		used[j] = true
+	}
+	leftovers := []*Event{}
+	for j, cEv := range calEvents {
+		if !used[j] {
+			leftovers = append(leftovers, cEv)
+		}
+	}
+	return pairs, leftovers
 }
 
-func getAttendees(config *parser.Config, ev *intra.Activity) *[]*calendar.EventAttendee {
-	var attendees []*calendar.EventAttendee
-
-	if !config.ProjectParticipant {
-		return nil
+// newClassEvent returns the calendar event of the intra event, or an error when its times are malformed
+func newClassEvent(config *parser.Config, ev intra.Event, loc *time.Location) (*Event, error) {
+	start, end, err := intra.EventTime(ev, loc)
+	if err != nil {
+		return nil, err
 	}
+	return &Event{
+		Summary:     ev.ActiTitle,
+		Location:    ev.Room.Code,
+		Description: ev.ModuleTitle,
+		Start:       start,
+		End:         end,
+		Color:       config.EventColor,
+		Reminders:   append([]int{}, config.Reminders...),
+		Properties:  classProperties(ev),
+	}, nil
+}
 
-	for index, at := range ev.Participants {
-		attendees = append(attendees, &calendar.EventAttendee{
-			Email:       at,
-			DisplayName: ev.ParticipantsName[index],
-		})
+// newProjectEvent returns the calendar event of the project, or an error when its times are malformed
+func newProjectEvent(config *parser.Config, ev intra.Activity, loc *time.Location) (*Event, error) {
+	start, end, err := intra.ActivityTime(ev, loc)
+	if err != nil {
+		return nil, err
 	}
-	return &attendees
+	return &Event{
+		Summary:     ev.Title,
+		Description: normalizeNewlines(ev.Description),
+		Start:       start,
+		End:         end,
+		Color:       config.ProjectColor,
+		Attendees:   getAttendees(config, &ev),
+		Properties:  projectProperties(ev),
+	}, nil
 }
 
-func createProjects(srv *calendar.Service, config *parser.Config, projects *[]intra.Activity) {
-	calEvents := GetEvents(srv, config.GoogleCalendarProjects)
-	for _, cEv := range calEvents.Items {
-		for index, ev := range *projects {
-			if cEv.Summary == ev.Title { // on create event the acti code is written in the description.
-				if config.ProjectParticipant && ev.Participants != nil && cEv.Attendees == nil {
-					//Case where the project was already created before but now the
-					//Project started and the group has been created.
-					//This way the group is added to the event, provided the
-					//option is enabled in the config.
-					(*projects)[index].Update = true
-					(*projects)[index].ID = cEv.Id
-					break
-				}
-				if index < len(*projects) {
-					(*projects)[index] = (*projects)[len(*projects)-1]
-				}
-				*projects = (*projects)[:len(*projects)-1]
-				break
+// planProjects computes the changes synchronising the projects calendar with the projects.
+// The project events of the modules in fetchErrs are kept, as their projects are unknown.
+func planProjects(ctx context.Context, backend CalendarBackend, config *parser.Config, projects *[]intra.Activity, fetchErrs intra.FetchErrors) ([]Change, error) {
+	ownedEvents := map[string]*Event{}
+	legacyEvents := []*Event{}
+	changes := []Change{}
+	calendarID := config.GoogleCalendarProjects
+
+	loc, err := intra.CampusLocation(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	from, _ := intra.PlanningWindow(config)
+	calEvents, err := backend.ListEvents(ctx, calendarID, from, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	for _, cEv := range calEvents {
+		if !backend.Owned(cEv) {
+			legacyEvents = append(legacyEvents, cEv)
+		} else if cEv.Properties[propertyKind] == kindProject {
+			if _, ok := ownedEvents[eventIdentity(cEv.Properties)]; ok { // duplicate, only one is kept
+				changes = append(changes, deleteChange(kindProject, calendarID, cEv, "duplicate"))
+				continue
 			}
+			ownedEvents[eventIdentity(cEv.Properties)] = cEv
 		}
 	}
 	for _, ev := range *projects {
-		start := parseTime(ev.Begin)
-		end := parseTime(ev.End)
-		newEvent := &calendar.Event{
-			Summary:     ev.Title,
-			Description: ev.Description,
-			Start: &calendar.EventDateTime{
-				DateTime: start,
-				TimeZone: config.Timezone,
-			},
-			End: &calendar.EventDateTime{
-				DateTime: end,
-				TimeZone: config.Timezone,
-			},
-			ColorId:   config.ProjectColor,
-			Attendees: *getAttendees(config, &ev),
+		identity := eventIdentity(projectProperties(ev))
+		newEvent, err := newProjectEvent(config, ev, loc)
+		if err != nil { // its calendar event, if any, is kept as is
+			Logger(ctx).Printf("Skipping the project %q of the intra: %v\n", ev.Title, err)
+			delete(ownedEvents, identity)
+			continue
 		}
-		if ev.Update {
-			_, err := srv.Events.Update(config.GoogleCalendarProjects, ev.ID, newEvent).Do()
-			if err != nil {
-				log.Printf("Unable to update event. %v\n", err)
+		if cEv, ok := ownedEvents[identity]; ok {
+			delete(ownedEvents, identity)
+			if len(newEvent.Attendees) == 0 && fetchErrs.Failed(ev.ScolarYear, ev.CodeModule, ev.CodeInstance, ev.CodeActi) {
+				newEvent.Attendees = cEv.Attendees // the group could not be fetched, the one already known is kept
 			}
-		} else {
-			_, err := srv.Events.Insert(config.GoogleCalendarProjects, newEvent).Do()
-			if err != nil {
-				log.Printf("Unable to create event. %v\n", err)
+			if eventChanged(cEv, newEvent) {
+				changes = append(changes, updateChange(kindProject, calendarID, cEv, newEvent, changeReason(cEv, newEvent)))
+			} else if config.ProjectParticipant && ev.Participants != nil && len(cEv.Attendees) == 0 {
+				//Case where the project was already created before but now the
+				//Project started and the group has been created.
+				//This way the group is added to the event, provided the
+				//option is enabled in the config.
+				changes = append(changes, updateChange(kindProject, calendarID, cEv, newEvent, "group created"))
 			}
+			continue
+		}
+		adopted := false
+		for index, cEv := range legacyEvents {
+			if cEv.Summary == ev.Title { // project created by an older version of the linker
+				changes = append(changes, updateChange(kindProject, calendarID, cEv, newEvent, "created by an older version"))
+				legacyEvents = append(legacyEvents[:index], legacyEvents[index+1:]...)
+				adopted = true
+				break
+			}
+		}
+		if !adopted {
+			changes = append(changes, insertChange(kindProject, calendarID, newEvent, "new project"))
 		}
 	}
+	kept := 0
+	for _, cEv := range ownedEvents { // whatever is left is a project we are no longer registered to
+		if fetchErrs.Failed(cEv.Properties[propertyYear], cEv.Properties[propertyModule], cEv.Properties[propertyInstance], "") {
+			kept++
+			continue
+		}
+		changes = append(changes, deleteChange(kindProject, calendarID, cEv, "no longer registered"))
+	}
+	if kept != 0 {
+		Logger(ctx).Printf("Kept %d project events of the modules that could not be fetched\n", kept)
+	}
+	return changes, nil
 }
 
-//CreateEvents creates the events passed on the callendar specified
-func CreateEvents(srv *calendar.Service, config *parser.Config, events *[]intra.Event, projects *[]intra.Activity) {
-	calEvents := GetEvents(srv, config.GoogleCalendarEvents)
-	eventReminders := []*calendar.EventReminder{}
+// planEvents computes the changes synchronising the events calendar with the events of the planning window.
+func planEvents(ctx context.Context, backend CalendarBackend, config *parser.Config, events *[]intra.Event) ([]Change, error) {
+	from, to := intra.PlanningWindow(config)
+	ownedEvents := map[string]*Event{}
+	legacyEvents := map[string][]*Event{}
+	pendingEvents := map[string][]*Event{}
+	skippedActis := map[string]bool{}
+	actis := []string{}
+	changes := []Change{}
+	calendarID := config.GoogleCalendarEvents
 
-	for _, cEv := range calEvents.Items {
-		for index, ev := range *events {
-			if cEv.Description == ev.CodeActi { // on create event the acti code is written in the description.
-				if index < len(*events) {
-					(*events)[index] = (*events)[len(*events)-1]
-				}
-				*events = (*events)[:len(*events)-1]
+	loc, err := intra.CampusLocation(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	calEvents, err := backend.ListEvents(ctx, calendarID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, cEv := range calEvents {
+		if !backend.Owned(cEv) {
+			if linkerDescription.MatchString(cEv.Description) { // older versions wrote the acti code in the description.
+				legacyEvents[cEv.Description] = append(legacyEvents[cEv.Description], cEv)
+			}
+		} else if cEv.Properties[propertyKind] == kindClass {
+			if _, ok := ownedEvents[eventIdentity(cEv.Properties)]; ok { // duplicate, only one is kept
+				changes = append(changes, deleteChange(kindClass, calendarID, cEv, "duplicate"))
+				continue
 			}
+			ownedEvents[eventIdentity(cEv.Properties)] = cEv
 		}
 	}
 
-	for _, val := range config.Reminders {
-		newReminder := calendar.EventReminder{
-			Method:  "popup",
-			Minutes: int64(val),
-		}
-		eventReminders = append(eventReminders, &newReminder)
-	}
 	for _, ev := range *events {
-		start, end := getTime(ev)
-		newEvent := &calendar.Event{
-			Summary:     ev.ActiTitle,
-			Location:    ev.Room.Code,
-			Description: ev.CodeActi,
-			Start: &calendar.EventDateTime{
-				DateTime: start,
-				TimeZone: config.Timezone,
-			},
-			End: &calendar.EventDateTime{
-				DateTime: end,
-				TimeZone: config.Timezone,
-			},
-			ColorId: config.EventColor,
-			Reminders: &calendar.EventReminders{
-				Overrides: eventReminders,
-			},
+		identity := eventIdentity(classProperties(ev))
+		newEvent, err := newClassEvent(config, ev, loc)
+		if err != nil { // its calendar event, if any, is kept as is
+			Logger(ctx).Printf("Skipping the event %q of the intra: %v\n", ev.ActiTitle, err)
+			delete(ownedEvents, identity)
+			skippedActis[ev.CodeActi] = true
+			continue
 		}
-		newEvent.Reminders.ForceSendFields = []string{"UseDefault"}
-		_, err := srv.Events.Insert(config.GoogleCalendarEvents, newEvent).Do()
-		if err != nil {
-			log.Printf("Unable to create event. %v\n", err)
+		if cEv, ok := ownedEvents[identity]; ok {
+			delete(ownedEvents, identity)
+			if eventChanged(cEv, newEvent) {
+				changes = append(changes, updateChange(kindClass, calendarID, cEv, newEvent, changeReason(cEv, newEvent)))
+			}
+			continue
+		}
+		if _, ok := pendingEvents[ev.CodeActi]; !ok {
+			actis = append(actis, ev.CodeActi)
 		}
+		pendingEvents[ev.CodeActi] = append(pendingEvents[ev.CodeActi], newEvent)
 	}
-	if projects != nil {
-		createProjects(srv, config, projects)
+
+	for _, acti := range actis {
+		pairs, leftovers := pairEvents(pendingEvents[acti], legacyEvents[acti])
+		legacyEvents[acti] = leftovers
+		for i, newEvent := range pendingEvents[acti] {
+			if pairs[i] == nil {
+				changes = append(changes, insertChange(kindClass, calendarID, newEvent, "new event"))
+			} else { // adopt the legacy event, it gets the linker properties
+				changes = append(changes, updateChange(kindClass, calendarID, pairs[i], newEvent, "created by an older version"))
+			}
+		}
 	}
-}
 
-// GetGoogleClient initialize a client in order to see and create calendar events
-func GetGoogleClient() *calendar.Service {
-	b, err := ioutil.ReadFile("credentials.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+	// whatever is left is no longer in the planning
+	for _, cEv := range ownedEvents {
+		changes = append(changes, deleteChange(kindClass, calendarID, cEv, "no longer in the planning"))
+	}
+	for acti, cEvs := range legacyEvents {
+		if skippedActis[acti] { // they may be the skipped events
+			continue
+		}
+		for _, cEv := range cEvs {
+			changes = append(changes, deleteChange(kindClass, calendarID, cEv, "no longer in the planning"))
+		}
 	}
+	return changes, nil
+}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, calendar.CalendarScope)
+// PlanEvents computes the changes synchronising the calendars with the events and the projects without
+// modifying anything: the calendars are only read. projects can be nil to leave the projects calendar untouched.
+// fetchErrs are the failures of intra.Client.RegisteredProjects: the project events of those modules are never deleted.
+// Events are identified by the linker properties set on creation. Events created by older
+// versions of the linker are recognised by their description and adopted.
+func PlanEvents(ctx context.Context, backend CalendarBackend, config *parser.Config, events *[]intra.Event, projects *[]intra.Activity, fetchErrs intra.FetchErrors) ([]Change, error) {
+	changes, err := planEvents(ctx, backend, config, events)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return nil, err
 	}
-	client := getClient(config)
+	if projects != nil {
+		projectChanges, err := planProjects(ctx, backend, config, projects, fetchErrs)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var calErr *CalendarError
+			if errors.As(err, &calErr) && calErr.Reason != nil { // the credentials or the config need fixing
+				return nil, err
+			}
+			Logger(ctx).Printf("Unable to retrieve the calendar projects, skipping them: %v\n", err)
+			return changes, nil
+		}
+		changes = append(changes, projectChanges...)
+	}
+	return changes, nil
+}
 
-	srv, err := calendar.New(client)
+// CreateEvents synchronises the events passed with the calendar specified: new events are inserted,
+// events moved or renamed on the intra are updated and events created by the linker that are no longer
+// in the planning window are deleted. When ctx is done, the changes already started are completed.
+// The failure to read the calendars, or the ChangeErrors of the changes that failed, is returned:
+// errors.Is tells ErrUnauthorized, ErrQuotaExceeded and ErrCalendarNotFound apart.
+func CreateEvents(ctx context.Context, backend CalendarBackend, config *parser.Config, events *[]intra.Event, projects *[]intra.Activity, fetchErrs intra.FetchErrors) error {
+	changes, err := PlanEvents(ctx, backend, config, events, projects, fetchErrs)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		return fmt.Errorf("unable to retrieve the calendar events, skipping synchronisation: %w", err)
 	}
-
-	return srv
+	return ApplyChanges(ctx, backend, changes)
 }
@@ -0,0 +1,279 @@
+package agenda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/intra"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+func testConfig() *parser.Config {
+	return &parser.Config{
+		Location:               "FR/TLS",
+		GoogleCalendarEvents:   "events",
+		GoogleCalendarProjects: "projects",
+		EventColor:             "3",
+		ProjectColor:           "10",
+	}
+}
+
+// intraTime returns the intra timestamp of the hour, days after today in the timezone of Toulouse
+func intraTime(t *testing.T, days int, hour int) string {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day()+days, hour, 0, 0, 0, loc).Format(intra.TimeLayout)
+}
+
+func classEvent(t *testing.T, acti string, event string, days int, hour int) intra.Event {
+	return intra.Event{
+		CodeModule:   "B-CPE-100",
+		CodeInstance: "TLS-1-1",
+		CodeActi:     acti,
+		CodeEvent:    event,
+		ScolarYear:   "2026",
+		ModuleTitle:  "Elementary programming in C",
+		ActiTitle:    "Bootstrap " + acti,
+		Start:        intraTime(t, days, hour),
+		End:          intraTime(t, days, hour+2),
+		Room:         intra.Room{Code: "Amphi"},
+	}
+}
+
+func project(t *testing.T, module string, acti string) intra.Activity {
+	return intra.Activity{
+		Title:        "Project " + acti,
+		Begin:        intraTime(t, -3, 8),
+		End:          intraTime(t, 10, 23),
+		CodeActi:     acti,
+		CodeModule:   module,
+		CodeInstance: "TLS-1-1",
+		ScolarYear:   "2026",
+	}
+}
+
+// projectEvent returns the calendar event of the project in the timezone of Toulouse
+func projectEvent(t *testing.T, config *parser.Config, project intra.Activity) *Event {
+	t.Helper()
+	loc, _ := time.LoadLocation("Europe/Paris")
+	ev, err := newProjectEvent(config, project, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// synchronise plans and applies the changes on the backend, and returns the planned changes
+func synchronise(t *testing.T, backend *MemoryBackend, events []intra.Event, projects *[]intra.Activity, fetchErrs intra.FetchErrors) []Change {
+	t.Helper()
+	ctx := context.Background()
+	changes, err := PlanEvents(ctx, backend, testConfig(), &events, projects, fetchErrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyChanges(ctx, backend, changes); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func listEvents(t *testing.T, backend *MemoryBackend, calendarID string) []*Event {
+	t.Helper()
+	events, err := backend.ListEvents(context.Background(), calendarID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func checkChanges(t *testing.T, changes []Change, want ...string) {
+	t.Helper()
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes %+v, want %v", len(changes), changes, want)
+	}
+	for i, change := range changes {
+		if got := change.Action + " " + change.Reason; got != want[i] {
+			t.Errorf("change %d is %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestSyncInsertsNewEvents(t *testing.T) {
+	backend := NewMemoryBackend()
+	events := []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9), classEvent(t, "acti-2", "event-2", 2, 14)}
+
+	checkChanges(t, synchronise(t, backend, events, nil, nil), "insert new event", "insert new event")
+	stored := listEvents(t, backend, "events")
+	if len(stored) != 2 || !backend.Owned(stored[0]) || stored[0].Summary != "Bootstrap acti-1" || stored[0].Location != "Amphi" {
+		t.Fatalf("unexpected calendar %+v", stored)
+	}
+	checkChanges(t, synchronise(t, backend, events, nil, nil))
+}
+
+func TestSyncUpdatesMovedEvents(t *testing.T) {
+	backend := NewMemoryBackend()
+	events := []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9)}
+	synchronise(t, backend, events, nil, nil)
+
+	events[0] = classEvent(t, "acti-1", "event-1", 1, 11)
+	checkChanges(t, synchronise(t, backend, events, nil, nil), "update time changed")
+	stored := listEvents(t, backend, "events")
+	if len(stored) != 1 || stored[0].Start.Hour() != 11 {
+		t.Fatalf("the event was not moved: %+v", stored)
+	}
+}
+
+func TestSyncDeletesUnregisteredEvents(t *testing.T) {
+	backend := NewMemoryBackend()
+	synchronise(t, backend, []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9), classEvent(t, "acti-2", "event-2", 2, 9)}, nil, nil)
+
+	checkChanges(t, synchronise(t, backend, []intra.Event{classEvent(t, "acti-2", "event-2", 2, 9)}, nil, nil),
+		"delete no longer in the planning")
+	if stored := listEvents(t, backend, "events"); len(stored) != 1 || stored[0].Properties[propertyActi] != "acti-2" {
+		t.Fatalf("unexpected calendar %+v", stored)
+	}
+}
+
+func TestSyncDeletesDuplicates(t *testing.T) {
+	backend := NewMemoryBackend()
+	events := []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9)}
+	synchronise(t, backend, events, nil, nil)
+	loc, _ := time.LoadLocation("Europe/Paris")
+	duplicate, err := newClassEvent(testConfig(), events[0], loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Insert(context.Background(), "events", duplicate); err != nil {
+		t.Fatal(err)
+	}
+
+	checkChanges(t, synchronise(t, backend, events, nil, nil), "delete duplicate")
+	if stored := listEvents(t, backend, "events"); len(stored) != 1 {
+		t.Fatalf("the duplicate was kept: %+v", stored)
+	}
+}
+
+func TestSyncAdoptsLegacyEvents(t *testing.T) {
+	backend := NewMemoryBackend()
+	ctx := context.Background()
+	loc, _ := time.LoadLocation("Europe/Paris")
+	legacy := classEvent(t, "acti-1", "event-1", 1, 9)
+	start, end, err := intra.EventTime(legacy, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calEvents := []*Event{
+		{Summary: "Bootstrap", Description: "acti-1", Start: start, End: end},                                   // created by an older version
+		{Summary: "Bootstrap", Description: "acti-1", Start: start.AddDate(0, 0, 1), End: end.AddDate(0, 0, 1)}, // older version, cancelled since
+		{Summary: "Dentist", Description: "not the linker", Start: start, End: end},                             // created by the user
+	}
+	for _, ev := range calEvents {
+		if err := backend.Insert(ctx, "events", ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkChanges(t, synchronise(t, backend, []intra.Event{legacy}, nil, nil),
+		"update created by an older version", "delete no longer in the planning")
+	stored := listEvents(t, backend, "events")
+	if len(stored) != 2 {
+		t.Fatalf("unexpected calendar %+v", stored)
+	}
+	for _, ev := range stored {
+		if ev.ID == calEvents[0].ID && (!backend.Owned(ev) || ev.Summary != "Bootstrap acti-1") {
+			t.Errorf("the legacy event was not adopted: %+v", ev)
+		}
+		if ev.ID != calEvents[0].ID && ev.ID != calEvents[2].ID {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	}
+	checkChanges(t, synchronise(t, backend, []intra.Event{legacy}, nil, nil))
+}
+
+func TestSyncKeepsProjectsOfFailedModules(t *testing.T) {
+	backend := NewMemoryBackend()
+	projects := []intra.Activity{project(t, "B-CPE-100", "acti-10"), project(t, "B-PSU-100", "acti-20")}
+	checkChanges(t, synchronise(t, backend, nil, &projects, nil), "insert new project", "insert new project")
+
+	fetchErrs := intra.FetchErrors{{Year: "2026", Module: "B-CPE-100", Instance: "TLS-1-1", Err: context.DeadlineExceeded}}
+	checkChanges(t, synchronise(t, backend, nil, &[]intra.Activity{}, fetchErrs), "delete no longer registered")
+	stored := listEvents(t, backend, "projects")
+	if len(stored) != 1 || stored[0].Properties[propertyModule] != "B-CPE-100" {
+		t.Fatalf("the project of the failed module was not kept: %+v", stored)
+	}
+}
+
+func TestSyncSkipsMalformedEvents(t *testing.T) {
+	backend := NewMemoryBackend()
+	events := []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9)}
+	projects := []intra.Activity{project(t, "B-CPE-100", "acti-10")}
+	synchronise(t, backend, events, &projects, nil)
+
+	events[0].End = "tomorrow"
+	projects[0].Begin = ""
+	events = append(events, classEvent(t, "acti-2", "event-2", 2, 9))
+	events[1].Start = "2026"
+	checkChanges(t, synchronise(t, backend, events, &projects, nil))
+	if stored := listEvents(t, backend, "events"); len(stored) != 1 || stored[0].Start.Year() == 1 {
+		t.Fatalf("unexpected calendar %+v", stored)
+	}
+	if stored := listEvents(t, backend, "projects"); len(stored) != 1 {
+		t.Fatalf("the project was not kept: %+v", stored)
+	}
+}
+
+// failingBackend is a memory backend whose listings of a calendar or inserts fail
+type failingBackend struct {
+	*MemoryBackend
+	listErrs  map[string]error // by calendar
+	insertErr error
+}
+
+func (b *failingBackend) ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error) {
+	if err := b.listErrs[calendarID]; err != nil {
+		return nil, err
+	}
+	return b.MemoryBackend.ListEvents(ctx, calendarID, from, to)
+}
+
+func (b *failingBackend) Insert(ctx context.Context, calendarID string, ev *Event) error {
+	if b.insertErr != nil {
+		return b.insertErr
+	}
+	return b.MemoryBackend.Insert(ctx, calendarID, ev)
+}
+
+func TestCreateEventsReturnsCalendarErrors(t *testing.T) {
+	unauthorized := &CalendarError{Calendar: "events", Reason: ErrUnauthorized, Err: errors.New("401 Unauthorized")}
+	notFound := &CalendarError{Calendar: "projects", Reason: ErrCalendarNotFound, Err: errors.New("404 Not Found")}
+	unavailable := &CalendarError{Calendar: "projects", Err: errors.New("503 Service Unavailable")}
+	tests := []struct {
+		name    string
+		backend *failingBackend
+		want    error
+	}{
+		{"listing unauthorized", &failingBackend{listErrs: map[string]error{"events": unauthorized}}, ErrUnauthorized},
+		{"inserts unauthorized", &failingBackend{insertErr: unauthorized}, ErrUnauthorized},
+		{"projects calendar not found", &failingBackend{listErrs: map[string]error{"projects": notFound}}, ErrCalendarNotFound},
+		{"projects calendar unavailable", &failingBackend{listErrs: map[string]error{"projects": unavailable}}, nil}, // skipped
+	}
+	for _, test := range tests {
+		test.backend.MemoryBackend = NewMemoryBackend()
+		events := []intra.Event{classEvent(t, "acti-1", "event-1", 1, 9), classEvent(t, "acti-2", "event-2", 2, 9)}
+		projects := []intra.Activity{project(t, "B-CPE-100", "acti-10")}
+
+		err := CreateEvents(context.Background(), test.backend, testConfig(), &events, &projects, nil)
+		if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+		var changeErrs ChangeErrors
+		if test.backend.insertErr != nil && (!errors.As(err, &changeErrs) || len(changeErrs) != 3) {
+			t.Errorf("%s: got %v, want the 3 failed inserts", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package agenda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/intra"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// Attendee is a participant of an event
+type Attendee struct {
+	Email string
+	Name  string
+}
+
+// Event is a calendar event as handled by the linker, independently of the calendar it is stored in.
+type Event struct {
+	ID          string // identifier of the event in the backend. Empty until the event is inserted.
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	Color       string // color id as written in the config file
+	Reminders   []int  // minutes before the event. nil keeps the calendar default reminders, an empty slice disables them
+	Attendees   []Attendee
+	Properties  map[string]string // linker properties identifying the intra activity. nil if the event was not created by the linker
+}
+
+// sortEvents orders the events by start time
+func sortEvents(events []*Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+}
+
+// Reasons of the calendar errors, to be tested with errors.Is
+var (
+	ErrUnauthorized     = errors.New("access denied, check the credentials or run the auth command to log in again")
+	ErrQuotaExceeded    = errors.New("quota exceeded, try again later")
+	ErrCalendarNotFound = errors.New("calendar not found, check the calendar ids of the config file")
+)
+
+// CalendarError is an error returned by a calendar backend. Calendar is empty when the request does not target a calendar.
+// Reason is ErrUnauthorized, ErrQuotaExceeded, ErrCalendarNotFound or nil when the failure is none of those.
+type CalendarError struct {
+	Calendar string
+	Reason   error
+	Err      error
+}
+
+func (e *CalendarError) Error() string {
+	msg := e.Err.Error()
+	if e.Reason != nil {
+		msg = e.Reason.Error() + ": " + msg
+	}
+	if e.Calendar != "" {
+		msg = "calendar " + e.Calendar + ": " + msg
+	}
+	return msg
+}
+
+// Unwrap returns the error of the backend
+func (e *CalendarError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells if target is the reason of the error
+func (e *CalendarError) Is(target error) bool {
+	return e.Reason != nil && target == e.Reason
+}
+
+// statusReason returns the reason of an HTTP status of a calendar server.
+// Not found is only a missing calendar when calendarRequest is set, as it may be a missing event otherwise.
+func statusReason(status int, calendarRequest bool) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrUnauthorized
+	case status == http.StatusTooManyRequests:
+		return ErrQuotaExceeded
+	case status == http.StatusNotFound && calendarRequest:
+		return ErrCalendarNotFound
+	}
+	return nil
+}
+
+// CalendarBackend is implemented by every calendar the linker is able to synchronise to.
+// Calendar ids are the ones written in the config file.
+type CalendarBackend interface {
+	// ListEvents lists the events of the calendar ending after from and starting before to.
+	// A zero to means no upper bound.
+	ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error)
+	// Insert creates the event in the calendar and sets its ID.
+	Insert(ctx context.Context, calendarID string, ev *Event) error
+	// Update replaces the event of the calendar having the same ID.
+	Update(ctx context.Context, calendarID string, ev *Event) error
+	// Delete removes the event from the calendar.
+	Delete(ctx context.Context, calendarID string, ev *Event) error
+	// Owned tells if the event was created by the linker.
+	Owned(ev *Event) bool
+}
+
+// Operation is a change of a calendar event performed by a BatchBackend
+type Operation struct {
+	Action   string // ActionInsert, ActionUpdate or ActionDelete
+	Calendar string
+	Event    *Event
+}
+
+// BatchBackend is implemented by the backends able to perform several operations in a single request.
+type BatchBackend interface {
+	CalendarBackend
+	// Batch performs the operations and returns their errors, nil for the successful ones.
+	// The IDs of the inserted events are set like Insert does. Once ctx is done, the requests already sent
+	// are completed but the remaining operations are not sent and get the error of ctx.
+	Batch(ctx context.Context, ops []Operation) []error
+}
+
+// detachedContext keeps the values of its parent context but is never done, so that a change of a calendar
+// already started is completed when the synchronisation is interrupted.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// Authenticate runs the login flow of the backend selected in the config file and saves the new token
+// to the token_file or the secret store of the config, replacing the previous one if any.
+func Authenticate(ctx context.Context, config *parser.Config) error {
+	switch config.Backend {
+	case "", "google":
+		oauthConfig, err := googleOAuthConfig(config.CredentialsFile)
+		if err != nil {
+			return err
+		}
+		tokens, err := newTokenStorage(config, config.TokenFile)
+		if err != nil {
+			return err
+		}
+		tok, err := getTokenFromWeb(ctx, oauthConfig)
+		if err != nil {
+			return err
+		}
+		return tokens.save(tok)
+	case "outlook":
+		oauthConfig := outlookOAuthConfig(config.OutlookClientID, config.OutlookTenant, microsoftURL)
+		tokens, err := newTokenStorage(config, outlookTokenFile(config.TokenFile))
+		if err != nil {
+			return err
+		}
+		tok, err := getTokenFromDevice(ctx, oauthConfig, outlookDeviceURL(oauthConfig))
+		if err != nil {
+			return err
+		}
+		return tokens.save(tok)
+	default:
+		return fmt.Errorf("the %s backend does not need to log in", config.Backend)
+	}
+}
+
+// NewBackend creates the calendar backend selected by the calendar_backend field of the config file.
+// ctx bounds the login flow run when no token was saved yet.
+func NewBackend(ctx context.Context, config *parser.Config) (CalendarBackend, error) {
+	switch config.Backend {
+	case "", "google":
+		client, err := googleHTTPClient(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		return NewGoogleBackend(client, intra.CampusTimezone(config), config.GoogleBatchSize)
+	case "caldav":
+		return NewCalDAVBackend(config.CalDAVURL, config.CalDAVUsername, config.CalDAVPassword, intra.CampusTimezone(config))
+	case "outlook":
+		client, err := GetOutlookClient(ctx, config, microsoftURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewOutlookBackend(client, graphURL, intra.CampusTimezone(config)), nil
+	default:
+		return nil, fmt.Errorf("unknown calendar backend %q", config.Backend)
+	}
+}
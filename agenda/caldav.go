@@ -0,0 +1,405 @@
+package agenda
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const caldavTimeout = 30
+
+// multistatus is the WebDAV answer to PROPFIND and REPORT requests
+type multistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+type davResourceType struct {
+	Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+}
+
+// davProp holds every property the backend asks for. Absent ones are left empty.
+type davProp struct {
+	CurrentUserPrincipal davHref         `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      davHref         `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	DisplayName          string          `xml:"DAV: displayname"`
+	ResourceType         davResourceType `xml:"DAV: resourcetype"`
+	ETag                 string          `xml:"DAV: getetag"`
+	CTag                 string          `xml:"http://calendarserver.org/ns/ getctag"`
+	SyncToken            string          `xml:"DAV: sync-token"`
+	CalendarData         string          `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+// prop merges the properties of the successful propstats of the response
+func (r *davResponse) prop() davProp {
+	for _, propstat := range r.Propstats {
+		if strings.Contains(propstat.Status, " 200 ") {
+			return propstat.Prop
+		}
+	}
+	return davProp{}
+}
+
+// caldavListing is the last listing of a calendar collection, reused as long as the collection tag does not change.
+type caldavListing struct {
+	tag    string
+	from   time.Time
+	to     time.Time
+	events []*Event
+}
+
+// CalDAVBackend is the calendar backend storing the events on a CalDAV server (Nextcloud, Radicale, Baïkal...)
+// Each event is a resource of the calendar collection named after its UID, which is derived from the intra codes.
+type CalDAVBackend struct {
+	client   *http.Client
+	baseURL  *url.URL
+	username string
+	password string
+	location *time.Location
+
+	mu          sync.Mutex
+	collections map[string]string        // calendar id from the config file -> collection url
+	etags       map[string]string        // resource url -> last known ETag
+	uids        map[string]string        // resource url -> UID of its event
+	listings    map[string]caldavListing // collection url -> last listing
+}
+
+// NewCalDAVBackend creates a backend for the CalDAV server. Events are created in the given timezone.
+func NewCalDAVBackend(serverURL string, username string, password string, timezone string) (*CalDAVBackend, error) {
+	baseURL, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caldav url: %v", err)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	return &CalDAVBackend{
+		client:      &http.Client{Timeout: caldavTimeout * time.Second},
+		baseURL:     baseURL,
+		username:    username,
+		password:    password,
+		location:    location,
+		collections: map[string]string{},
+		etags:       map[string]string{},
+		uids:        map[string]string{},
+		listings:    map[string]caldavListing{},
+	}, nil
+}
+
+// resolve turns an href sent by the server in an absolute url
+func (c *CalDAVBackend) resolve(href string) string {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return c.baseURL.ResolveReference(ref).String()
+}
+
+// do executes a request on the server with the credentials and checks the status code
+func (c *CalDAVBackend) do(ctx context.Context, method string, target string, headers map[string]string, body string) (*http.Response, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &CalendarError{
+			Reason: statusReason(resp.StatusCode, method == "PROPFIND" || method == "REPORT"),
+			Err:    fmt.Errorf("caldav %s %s: %s", method, target, resp.Status),
+		}
+	}
+	return resp, nil
+}
+
+// xmlRequest executes a PROPFIND or REPORT request and decodes the multistatus answer
+func (c *CalDAVBackend) xmlRequest(ctx context.Context, method string, target string, depth string, body string) (*multistatus, error) {
+	resp, err := c.do(ctx, method, target, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ms := &multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, fmt.Errorf("caldav %s %s: invalid answer: %v", method, target, err)
+	}
+	return ms, nil
+}
+
+// propfind retrieves the properties of the target
+func (c *CalDAVBackend) propfind(ctx context.Context, target string, depth string, props string) (*multistatus, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">` +
+		`<d:prop>` + props + `</d:prop></d:propfind>`
+	return c.xmlRequest(ctx, "PROPFIND", target, depth, body)
+}
+
+// discoverCalendars finds the calendar collections of the user, following the
+// current-user-principal and calendar-home-set properties from the server url.
+// Returns the collection urls by display name and by last path segment.
+func (c *CalDAVBackend) discoverCalendars(ctx context.Context) (map[string]string, error) {
+	home := c.baseURL.String()
+
+	ms, err := c.propfind(ctx, home, "0", "<d:current-user-principal/>")
+	if err == nil && len(ms.Responses) > 0 {
+		if principal := ms.Responses[0].prop().CurrentUserPrincipal.Href; principal != "" {
+			ms, err = c.propfind(ctx, c.resolve(principal), "0", "<c:calendar-home-set/>")
+			if err == nil && len(ms.Responses) > 0 && ms.Responses[0].prop().CalendarHomeSet.Href != "" {
+				home = c.resolve(ms.Responses[0].prop().CalendarHomeSet.Href)
+			}
+		}
+	}
+
+	ms, err = c.propfind(ctx, home, "1", "<d:resourcetype/><d:displayname/>")
+	if err != nil {
+		return nil, err
+	}
+	calendars := map[string]string{}
+	for _, resp := range ms.Responses {
+		prop := resp.prop()
+		if prop.ResourceType.Calendar == nil {
+			continue
+		}
+		collection := c.resolve(resp.Href)
+		calendars[path.Base(strings.TrimSuffix(resp.Href, "/"))] = collection
+		if prop.DisplayName != "" {
+			calendars[prop.DisplayName] = collection
+		}
+	}
+	return calendars, nil
+}
+
+// collection returns the url of the calendar collection for a calendar id of the config file.
+// The id is either an url or path of the collection, or the name of a calendar of the user.
+func (c *CalDAVBackend) collection(ctx context.Context, calendarID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if collection, ok := c.collections[calendarID]; ok {
+		return collection, nil
+	}
+	if strings.Contains(calendarID, "/") {
+		collection := strings.TrimSuffix(c.resolve(calendarID), "/") + "/"
+		c.collections[calendarID] = collection
+		return collection, nil
+	}
+	calendars, err := c.discoverCalendars(ctx)
+	if err != nil {
+		return "", err
+	}
+	collection, ok := calendars[calendarID]
+	if !ok {
+		return "", &CalendarError{Calendar: calendarID, Reason: ErrCalendarNotFound,
+			Err: fmt.Errorf("no caldav collection named %q", calendarID)}
+	}
+	collection = strings.TrimSuffix(collection, "/") + "/"
+	c.collections[calendarID] = collection
+	return collection, nil
+}
+
+// collectionTag returns the ctag of the collection, or its sync-token if the server does not support ctags.
+// Both change every time a resource of the collection changes.
+func (c *CalDAVBackend) collectionTag(ctx context.Context, collection string) string {
+	ms, err := c.propfind(ctx, collection, "0", "<cs:getctag/><d:sync-token/>")
+	if err != nil || len(ms.Responses) == 0 {
+		return ""
+	}
+	prop := ms.Responses[0].prop()
+	if prop.CTag != "" {
+		return prop.CTag
+	}
+	return prop.SyncToken
+}
+
+// ListEvents lists the events of the collection ending after from and starting before to.
+// The collection is only queried again when its tag changed since the last listing.
+func (c *CalDAVBackend) ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error) {
+	collection, err := c.collection(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	tag := c.collectionTag(ctx, collection)
+
+	c.mu.Lock()
+	listing, ok := c.listings[collection]
+	c.mu.Unlock()
+	if !ok || tag == "" || listing.tag != tag || from.Before(listing.from) ||
+		(!listing.to.IsZero() && (to.IsZero() || to.After(listing.to))) {
+		listing, err = c.queryEvents(ctx, collection, from, to)
+		if err != nil {
+			return nil, err
+		}
+		listing.tag = tag
+		c.mu.Lock()
+		c.listings[collection] = listing
+		c.mu.Unlock()
+	}
+
+	events := []*Event{}
+	for _, ev := range listing.events {
+		if ev.End.After(from) && (to.IsZero() || ev.Start.Before(to)) {
+			events = append(events, copyEvent(ev))
+		}
+	}
+	return events, nil
+}
+
+// queryEvents runs a calendar-query REPORT on the collection for the VEVENTs overlapping the window
+func (c *CalDAVBackend) queryEvents(ctx context.Context, collection string, from time.Time, to time.Time) (caldavListing, error) {
+	timeRange := fmt.Sprintf(`<c:time-range start="%s"`, from.UTC().Format(icalUTCDateTime))
+	if !to.IsZero() {
+		timeRange += fmt.Sprintf(` end="%s"`, to.UTC().Format(icalUTCDateTime))
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+		`<d:prop><d:getetag/><c:calendar-data/></d:prop>` +
+		`<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">` +
+		timeRange + `/>` +
+		`</c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`
+
+	ms, err := c.xmlRequest(ctx, "REPORT", collection, "1", body)
+	if err != nil {
+		return caldavListing{}, err
+	}
+	listing := caldavListing{from: from, to: to}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resp := range ms.Responses {
+		prop := resp.prop()
+		if prop.CalendarData == "" {
+			continue
+		}
+		resource := c.resolve(resp.Href)
+		c.etags[resource] = prop.ETag
+		events, uids := parseVEvents(prop.CalendarData, c.location)
+		if len(events) == 0 {
+			continue
+		}
+		ev := events[0] // the first VEVENT is the master one for recurring events
+		ev.ID = resource
+		c.uids[resource] = uids[0]
+		listing.events = append(listing.events, ev)
+	}
+	sortEvents(listing.events)
+	return listing, nil
+}
+
+// put uploads the event. The If-None-Match or If-Match precondition avoids overwriting a resource changed by someone else.
+func (c *CalDAVBackend) put(ctx context.Context, resource string, ev *Event, uid string, precondition map[string]string) error {
+	headers := map[string]string{"Content-Type": "text/calendar; charset=utf-8"}
+	for key, val := range precondition {
+		headers[key] = val
+	}
+	resp, err := c.do(ctx, "PUT", resource, headers, icalCalendar(ev, uid, c.location))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	c.mu.Lock()
+	c.etags[resource] = resp.Header.Get("ETag")
+	c.uids[resource] = uid
+	c.mu.Unlock()
+	return nil
+}
+
+// Insert creates a resource named after the UID of the event in the collection
+func (c *CalDAVBackend) Insert(ctx context.Context, calendarID string, ev *Event) error {
+	collection, err := c.collection(ctx, calendarID)
+	if err != nil {
+		return err
+	}
+	uid := eventUID(ev)
+	resource := collection + url.PathEscape(uid) + ".ics"
+	if err := c.put(ctx, resource, ev, uid, map[string]string{"If-None-Match": "*"}); err != nil {
+		return err
+	}
+	ev.ID = resource
+	return nil
+}
+
+// Update replaces the resource of the event, provided it did not change since it was listed.
+// The resource keeps its UID, servers refuse UID changes.
+func (c *CalDAVBackend) Update(ctx context.Context, calendarID string, ev *Event) error {
+	c.mu.Lock()
+	etag := c.etags[ev.ID]
+	uid := c.uids[ev.ID]
+	c.mu.Unlock()
+
+	if uid == "" {
+		uid = eventUID(ev)
+	}
+	precondition := map[string]string{}
+	if etag != "" {
+		precondition["If-Match"] = etag
+	}
+	return c.put(ctx, ev.ID, ev, uid, precondition)
+}
+
+// Delete removes the resource of the event
+func (c *CalDAVBackend) Delete(ctx context.Context, calendarID string, ev *Event) error {
+	c.mu.Lock()
+	etag := c.etags[ev.ID]
+	c.mu.Unlock()
+
+	headers := map[string]string{}
+	if etag != "" {
+		headers["If-Match"] = etag
+	}
+	resp, err := c.do(ctx, "DELETE", ev.ID, headers, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	delete(c.etags, ev.ID)
+	delete(c.uids, ev.ID)
+	c.mu.Unlock()
+	return nil
+}
+
+// Owned tells if the event carries the linker property
+func (c *CalDAVBackend) Owned(ev *Event) bool {
+	return ev.Properties[propertyLinker] == linkerMarker
+}
@@ -0,0 +1,205 @@
+package agenda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// caldavStub is an in-process CalDAV server holding a single calendar, /calendars/me/epitech/, named Epitech
+type caldavStub struct {
+	mu        sync.Mutex
+	resources map[string]string // path -> calendar data
+	etags     map[string]string // path -> etag
+	ctag      int
+	reports   int // calendar-query REPORTs received
+}
+
+func newCalDAVStub(t *testing.T) (*caldavStub, *httptest.Server) {
+	stub := &caldavStub{resources: map[string]string{}, etags: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "student" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		stub.serve(t, w, r)
+	}))
+	return stub, server
+}
+
+func multistatusXML(responses ...string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">` +
+		strings.Join(responses, "") + `</d:multistatus>`
+}
+
+func responseXML(href string, props string) string {
+	return `<d:response><d:href>` + href + `</d:href><d:propstat><d:prop>` + props +
+		`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`
+}
+
+func (s *caldavStub) serve(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "PROPFIND" && r.URL.Path == "/":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatusXML(responseXML("/", `<d:current-user-principal><d:href>/principals/me/</d:href></d:current-user-principal>`)))
+	case r.Method == "PROPFIND" && r.URL.Path == "/principals/me/":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatusXML(responseXML("/principals/me/", `<c:calendar-home-set><d:href>/calendars/me/</d:href></c:calendar-home-set>`)))
+	case r.Method == "PROPFIND" && r.URL.Path == "/calendars/me/":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatusXML(
+			responseXML("/calendars/me/", `<d:resourcetype><d:collection/></d:resourcetype>`),
+			responseXML("/calendars/me/epitech/", `<d:resourcetype><d:collection/><c:calendar/></d:resourcetype><d:displayname>Epitech</d:displayname>`)))
+	case r.Method == "PROPFIND" && r.URL.Path == "/calendars/me/epitech/":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatusXML(responseXML(r.URL.Path, fmt.Sprintf(`<cs:getctag>ctag-%d</cs:getctag>`, s.ctag))))
+	case r.Method == "REPORT" && r.URL.Path == "/calendars/me/epitech/":
+		s.reports++
+		responses := []string{}
+		for href, data := range s.resources {
+			responses = append(responses, responseXML(href, `<d:getetag>`+s.etags[href]+`</d:getetag><c:calendar-data>`+xmlEscape(data)+`</c:calendar-data>`))
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatusXML(responses...))
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/calendars/me/epitech/"):
+		_, exists := s.resources[r.URL.Path]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != s.etags[r.URL.Path] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.resources[r.URL.Path] = string(body)
+		s.ctag++
+		s.etags[r.URL.Path] = fmt.Sprintf(`"etag-%d"`, s.ctag)
+		w.Header().Set("ETag", s.etags[r.URL.Path])
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "DELETE":
+		if match := r.Header.Get("If-Match"); match != "" && match != s.etags[r.URL.Path] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.resources, r.URL.Path)
+		s.ctag++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func xmlEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// editElsewhere changes the etag of every resource, as if another client had modified them
+func (s *caldavStub) editElsewhere() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctag++
+	for href := range s.etags {
+		s.etags[href] = fmt.Sprintf(`"elsewhere-%d"`, s.ctag)
+	}
+}
+
+func TestCalDAVBackend(t *testing.T) {
+	stub, server := newCalDAVStub(t)
+	defer server.Close()
+	ctx := context.Background()
+	backend, err := NewCalDAVBackend(server.URL+"/", "student", "secret", "Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := project(t, "B-CPE-100", "acti-10")
+	project.Description = "Write a shell.\r\nIn C, of course; with pipes"
+	wanted := projectEvent(t, testConfig(), project)
+
+	// discovery follows the principal and the home set to the calendar named Epitech
+	ev := copyEvent(wanted)
+	if err := backend.Insert(ctx, "Epitech", ev); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ev.ID, server.URL+"/calendars/me/epitech/") || !strings.HasSuffix(ev.ID, ".ics") {
+		t.Fatalf("unexpected resource %s", ev.ID)
+	}
+	// If-None-Match: * refuses to overwrite the resource of the same activity
+	if err := backend.Insert(ctx, "Epitech", copyEvent(wanted)); err == nil {
+		t.Fatal("a second insert of the same activity overwrote the first one")
+	}
+
+	// the listing is only queried again when the ctag changes
+	from := time.Now().AddDate(0, 0, -7)
+	events, err := backend.ListEvents(ctx, "Epitech", from, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.ListEvents(ctx, "Epitech", from, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if stub.reports != 1 {
+		t.Errorf("%d REPORTs for an unchanged collection, want 1", stub.reports)
+	}
+	if len(events) != 1 || !backend.Owned(events[0]) || eventIdentity(events[0].Properties) != eventIdentity(wanted.Properties) {
+		t.Fatalf("unexpected listing %+v", events)
+	}
+	if eventChanged(events[0], wanted) {
+		t.Errorf("the event changed on the round trip: %q, want %q", events[0].Description, wanted.Description)
+	}
+
+	// If-Match refuses to overwrite an event modified by someone else since it was listed
+	stub.editElsewhere()
+	moved := copyEvent(wanted)
+	moved.ID = events[0].ID
+	moved.Start = moved.Start.Add(time.Hour)
+	var calErr *CalendarError
+	if err := backend.Update(ctx, "Epitech", moved); !errors.As(err, &calErr) {
+		t.Fatalf("an event modified elsewhere was overwritten: %v", err)
+	}
+	if events, err = backend.ListEvents(ctx, "Epitech", from, time.Time{}); err != nil || stub.reports != 2 {
+		t.Fatalf("the listing was not refreshed after the ctag changed: %v", err)
+	}
+	if err := backend.Update(ctx, "Epitech", moved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Delete(ctx, "Epitech", moved); err != nil {
+		t.Fatal(err)
+	}
+	if events, err = backend.ListEvents(ctx, "Epitech", from, time.Time{}); err != nil || len(events) != 0 {
+		t.Fatalf("the event was not deleted: %+v %v", events, err)
+	}
+}
+
+func TestCalDAVUnknownCalendar(t *testing.T) {
+	_, server := newCalDAVStub(t)
+	defer server.Close()
+	backend, err := NewCalDAVBackend(server.URL+"/", "student", "secret", "Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.ListEvents(context.Background(), "Personal", time.Now(), time.Time{})
+	if !errors.Is(err, ErrCalendarNotFound) {
+		t.Fatalf("got %v, want ErrCalendarNotFound", err)
+	}
+}
+
+func TestNewProjectEventNormalizesNewlines(t *testing.T) {
+	project := project(t, "B-CPE-100", "acti-10")
+	project.Description = "a\r\nb\rc"
+	if ev := projectEvent(t, testConfig(), project); ev.Description != "a\nb\nc" {
+		t.Fatalf("got %q", ev.Description)
+	}
+}
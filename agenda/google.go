@@ -0,0 +1,302 @@
+package agenda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Retrieve a token from tokens, or from the web then saves it, then returns the generated client.
+// The refreshed tokens are saved as well.
+func getClient(ctx context.Context, config *oauth2.Config, tokens *tokenStorage) (*http.Client, error) {
+	tok, err := tokens.load()
+	if err != nil {
+		tok, err = getTokenFromWeb(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := tokens.save(tok); err != nil {
+			return nil, err
+		}
+	}
+	return persistentClient(config, tok, tokens), nil
+}
+
+// Request a token from the web, then returns the retrieved token. The user logs in in the browser, redirected
+// to a loopback listener of the linker. Google does not allow the calendar scopes in the device code flow.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for the login redirect: %v", err)
+	}
+	return getTokenFromLoopback(ctx, config, listener)
+}
+
+// Retrieves a token from a local file.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// Saves a token to a file path.
+func saveToken(path string, token *oauth2.Token) error {
+	fmt.Printf("Saving credential file to: %s\n", path)
+	return writeToken(path, token)
+}
+
+// writeToken replaces the token file at path. The token is written to a temporary file renamed over the previous one,
+// so that the token is never left half-written.
+func writeToken(path string, token *oauth2.Token) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	return nil
+}
+
+// quotaReasons are the reasons of the google 403 errors caused by the rate limits rather than by the permissions
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+}
+
+// googleError wraps an error of the calendar API into a CalendarError.
+// calendarRequest tells if the request targets the calendar itself, a not found meaning the calendar is missing.
+func googleError(calendarID string, err error, calendarRequest bool) error {
+	if err == nil {
+		return nil
+	}
+	calErr := &CalendarError{Calendar: calendarID, Err: err}
+	var apiErr *googleapi.Error
+	var tokenErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &tokenErr):
+		calErr.Reason = ErrUnauthorized
+	case errors.As(err, &apiErr):
+		calErr.Reason = statusReason(apiErr.Code, calendarRequest)
+		for _, item := range apiErr.Errors {
+			if apiErr.Code == http.StatusForbidden && quotaReasons[item.Reason] {
+				calErr.Reason = ErrQuotaExceeded
+			}
+		}
+	}
+	return calErr
+}
+
+// GetEvents lists the events of the calendar ending after from and starting before to, following every page.
+// A zero to means no upper bound.
+func GetEvents(ctx context.Context, srv *calendar.Service, agenda string, from time.Time, to time.Time) ([]*calendar.Event, error) {
+	calEvents := []*calendar.Event{}
+	call := srv.Events.List(agenda).ShowDeleted(false).SingleEvents(true).
+		TimeMin(from.Format(time.RFC3339)).MaxResults(250).OrderBy("startTime")
+	if !to.IsZero() {
+		call = call.TimeMax(to.Format(time.RFC3339))
+	}
+	err := call.Pages(ctx, func(page *calendar.Events) error {
+		calEvents = append(calEvents, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, googleError(agenda, err, true)
+	}
+	return calEvents, nil
+}
+
+// GoogleBackend is the calendar backend storing the events in Google Calendar
+type GoogleBackend struct {
+	srv       *calendar.Service
+	client    *http.Client
+	timezone  string
+	batchSize int
+}
+
+// NewGoogleBackend creates a backend using the authenticated client. Events are created in the given timezone.
+// Changes are sent by batches of batchSize requests, 0 meaning the maximum of 50.
+func NewGoogleBackend(client *http.Client, timezone string, batchSize int) (*GoogleBackend, error) {
+	srv, err := calendar.New(client)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 || batchSize > maxBatchSize {
+		batchSize = maxBatchSize
+	}
+	return &GoogleBackend{srv: srv, client: client, timezone: timezone, batchSize: batchSize}, nil
+}
+
+// parseGoogleTime converts a google calendar date, which is either a date time or a date for all day events.
+func parseGoogleTime(date *calendar.EventDateTime) time.Time {
+	if date == nil {
+		return time.Time{}
+	}
+	if date.DateTime != "" {
+		t, _ := time.Parse(time.RFC3339, date.DateTime)
+		return t
+	}
+	t, _ := time.ParseInLocation("2006-01-02", date.Date, time.Local)
+	return t
+}
+
+func fromGoogleEvent(cEv *calendar.Event) *Event {
+	ev := &Event{
+		ID:          cEv.Id,
+		Summary:     cEv.Summary,
+		Description: cEv.Description,
+		Location:    cEv.Location,
+		Start:       parseGoogleTime(cEv.Start),
+		End:         parseGoogleTime(cEv.End),
+		Color:       cEv.ColorId,
+	}
+	if cEv.Reminders != nil && !cEv.Reminders.UseDefault {
+		ev.Reminders = []int{}
+		for _, reminder := range cEv.Reminders.Overrides {
+			ev.Reminders = append(ev.Reminders, int(reminder.Minutes))
+		}
+	}
+	for _, at := range cEv.Attendees {
+		ev.Attendees = append(ev.Attendees, Attendee{Email: at.Email, Name: at.DisplayName})
+	}
+	if cEv.ExtendedProperties != nil && cEv.ExtendedProperties.Private != nil {
+		ev.Properties = cEv.ExtendedProperties.Private
+	}
+	return ev
+}
+
+func (g *GoogleBackend) toGoogleEvent(ev *Event) *calendar.Event {
+	newEvent := &calendar.Event{
+		Summary:     ev.Summary,
+		Description: ev.Description,
+		Location:    ev.Location,
+		Start: &calendar.EventDateTime{
+			DateTime: ev.Start.Format(time.RFC3339),
+			TimeZone: g.timezone,
+		},
+		End: &calendar.EventDateTime{
+			DateTime: ev.End.Format(time.RFC3339),
+			TimeZone: g.timezone,
+		},
+		ColorId: ev.Color,
+	}
+	if ev.Reminders != nil {
+		eventReminders := []*calendar.EventReminder{}
+		for _, val := range ev.Reminders {
+			eventReminders = append(eventReminders, &calendar.EventReminder{
+				Method:  "popup",
+				Minutes: int64(val),
+			})
+		}
+		newEvent.Reminders = &calendar.EventReminders{
+			Overrides: eventReminders,
+		}
+		newEvent.Reminders.ForceSendFields = []string{"UseDefault"}
+	}
+	for _, at := range ev.Attendees {
+		newEvent.Attendees = append(newEvent.Attendees, &calendar.EventAttendee{
+			Email:       at.Email,
+			DisplayName: at.Name,
+		})
+	}
+	if ev.Properties != nil {
+		newEvent.ExtendedProperties = &calendar.EventExtendedProperties{
+			Private: ev.Properties,
+		}
+	}
+	return newEvent
+}
+
+// ListEvents lists the events of the google calendar ending after from and starting before to
+func (g *GoogleBackend) ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error) {
+	calEvents, err := GetEvents(ctx, g.srv, calendarID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	events := []*Event{}
+	for _, cEv := range calEvents {
+		events = append(events, fromGoogleEvent(cEv))
+	}
+	return events, nil
+}
+
+// Insert creates the event in the google calendar
+func (g *GoogleBackend) Insert(ctx context.Context, calendarID string, ev *Event) error {
+	created, err := g.srv.Events.Insert(calendarID, g.toGoogleEvent(ev)).Context(ctx).Do()
+	if err != nil {
+		return googleError(calendarID, err, true)
+	}
+	ev.ID = created.Id
+	return nil
+}
+
+// Update replaces the google calendar event
+func (g *GoogleBackend) Update(ctx context.Context, calendarID string, ev *Event) error {
+	_, err := g.srv.Events.Update(calendarID, ev.ID, g.toGoogleEvent(ev)).Context(ctx).Do()
+	return googleError(calendarID, err, false)
+}
+
+// Delete removes the event from the google calendar
+func (g *GoogleBackend) Delete(ctx context.Context, calendarID string, ev *Event) error {
+	return googleError(calendarID, g.srv.Events.Delete(calendarID, ev.ID).Context(ctx).Do(), false)
+}
+
+// Owned tells if the event carries the linker private extended properties
+func (g *GoogleBackend) Owned(ev *Event) bool {
+	return ev.Properties[propertyLinker] == linkerMarker
+}
+
+// googleOAuthConfig reads the client secret file and returns the oauth configuration of the calendar API
+func googleOAuthConfig(credentialsFile string) (*oauth2.Config, error) {
+	b, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := google.ConfigFromJSON(b, calendar.CalendarScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	return config, nil
+}
+
+// googleHTTPClient returns the client authenticated with the token of the config, logging in if there is none
+func googleHTTPClient(ctx context.Context, config *parser.Config) (*http.Client, error) {
+	oauthConfig, err := googleOAuthConfig(config.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := newTokenStorage(config, config.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return getClient(ctx, oauthConfig, tokens)
+}
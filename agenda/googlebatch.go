@@ -0,0 +1,260 @@
+package agenda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// maxBatchSize is the maximum number of requests of a calendar API batch
+const maxBatchSize = 50
+
+// batchAttempts is the number of times an operation failing with a temporary error is sent
+const batchAttempts = 4
+
+// batchRetryDelay is the delay before the second attempt, doubled for each of the following ones
+var batchRetryDelay = time.Second
+
+// batchItem is an operation of a batch with the result of its last attempt
+type batchItem struct {
+	op        Operation
+	err       error
+	permanent bool // the operation could not be encoded and was not sent
+	answered  bool // err is the answer of the server to this operation, rather than the failure of the whole batch
+}
+
+// temporary tells if the operation failed because of the rate limits or of the server, and can be retried.
+// An insert is only retried when the server answered that it was not performed, as inserting an event
+// that was created after all would duplicate it.
+func (item *batchItem) temporary() bool {
+	if item.err == nil || item.permanent || item.err == context.Canceled || item.err == context.DeadlineExceeded {
+		return false
+	}
+	apiErr, ok := item.err.(*googleapi.Error)
+	if item.op.Action == ActionInsert && (!ok || !item.answered) {
+		return false
+	}
+	if !ok {
+		return true
+	}
+	if apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500 {
+		return true
+	}
+	for _, reason := range apiErr.Errors {
+		if apiErr.Code == http.StatusForbidden && quotaReasons[reason.Reason] {
+			return true
+		}
+	}
+	return false
+}
+
+// Batch performs the operations with calendar API batch requests of at most batchSize operations.
+// Operations failing with a temporary error are sent again in a later batch, waiting longer after each attempt.
+// A batch already sent is completed even if ctx is done meanwhile.
+func (g *GoogleBackend) Batch(ctx context.Context, ops []Operation) []error {
+	items := make([]*batchItem, len(ops))
+	for i, op := range ops {
+		items[i] = &batchItem{op: op}
+	}
+
+	pending := items
+	for attempt := 0; attempt < batchAttempts && len(pending) != 0; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-time.After(time.Duration(1<<uint(attempt-1)) * batchRetryDelay):
+			case <-ctx.Done():
+			}
+		}
+		for start := 0; start < len(pending); start += g.batchSize {
+			end := start + g.batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			if ctx.Err() != nil {
+				for _, item := range pending[start:] {
+					item.err = ctx.Err()
+				}
+				break
+			}
+			g.sendBatch(detachedContext{ctx}, pending[start:end])
+		}
+		failed := []*batchItem{}
+		for _, item := range pending {
+			if item.temporary() {
+				failed = append(failed, item)
+			}
+		}
+		pending = failed
+	}
+
+	errs := make([]error, len(items))
+	for i, item := range items {
+		errs[i] = googleError(item.op.Calendar, item.err, item.op.Action == ActionInsert)
+	}
+	return errs
+}
+
+// batchURL returns the batch endpoint of the calendar service, and the path of the service the requests are relative to
+func (g *GoogleBackend) batchURL() (string, string, error) {
+	base, err := url.Parse(g.srv.BasePath)
+	if err != nil {
+		return "", "", err
+	}
+	servicePath := base.Path
+	base.Path = "/batch" + strings.TrimSuffix(servicePath, "/")
+	return base.String(), servicePath, nil
+}
+
+// writeBatchItem writes the HTTP request of the operation as a part of the batch
+func (g *GoogleBackend) writeBatchItem(w *multipart.Writer, servicePath string, index int, op Operation) error {
+	method := "DELETE"
+	path := servicePath + "calendars/" + url.PathEscape(op.Calendar) + "/events"
+	var body []byte
+	switch op.Action {
+	case ActionInsert:
+		method = "POST"
+	case ActionUpdate:
+		method = "PUT"
+		path += "/" + url.PathEscape(op.Event.ID)
+	case ActionDelete:
+		path += "/" + url.PathEscape(op.Event.ID)
+	default:
+		return fmt.Errorf("unknown action %q", op.Action)
+	}
+	if op.Action != ActionDelete {
+		data, err := json.Marshal(g.toGoogleEvent(op.Event))
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/http"},
+		"Content-Id":   {"<item-" + strconv.Itoa(index) + ">"},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(part, "%s %s HTTP/1.1\r\n", method, path)
+	if body != nil {
+		fmt.Fprintf(part, "Content-Type: application/json\r\nContent-Length: %d\r\n", len(body))
+	}
+	fmt.Fprint(part, "\r\n")
+	_, err = part.Write(body)
+	return err
+}
+
+// sendBatch sends the operations in a single batch request and stores the result of each of them.
+// Every operation gets the error of the batch request when it fails as a whole.
+func (g *GoogleBackend) sendBatch(ctx context.Context, items []*batchItem) {
+	fail := func(err error) {
+		for _, item := range items {
+			if item.err == nil {
+				item.err = err
+			}
+		}
+	}
+	target, servicePath, err := g.batchURL()
+	if err != nil {
+		fail(err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for i, item := range items {
+		item.err = g.writeBatchItem(w, servicePath, i, item.op)
+		item.permanent = item.err != nil
+		item.answered = false
+	}
+	w.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, buf)
+	if err != nil {
+		fail(err)
+		return
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+	resp, err := g.client.Do(req)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		fail(err)
+		return
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		fail(fmt.Errorf("invalid batch answer: %v", err))
+		return
+	}
+
+	answered := make([]bool, len(items))
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		index, ok := batchItemIndex(part.Header.Get("Content-Id"))
+		if !ok || index >= len(items) || answered[index] {
+			continue
+		}
+		answered[index] = true
+		items[index].err = g.readBatchItem(part, items[index].op)
+		items[index].answered = true
+	}
+	for i, item := range items {
+		if !answered[i] && item.err == nil {
+			item.err = fmt.Errorf("no answer in the batch for the %s of %q", item.op.Action, item.op.Event.Summary)
+		}
+	}
+}
+
+// batchItemIndex returns the index of the operation from the Content-ID of its answer: <response-item-N>
+func batchItemIndex(contentID string) (int, bool) {
+	contentID = strings.Trim(contentID, "<>")
+	if !strings.HasPrefix(contentID, "response-item-") {
+		return 0, false
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-item-"))
+	return index, err == nil && index >= 0
+}
+
+// readBatchItem parses the HTTP answer of an operation and sets the ID of the inserted event
+func (g *GoogleBackend) readBatchItem(part *multipart.Part, op Operation) error {
+	resp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		return fmt.Errorf("invalid batch answer: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	if op.Action != ActionInsert {
+		ioutil.ReadAll(resp.Body)
+		return nil
+	}
+	created := &calendar.Event{}
+	if err := json.NewDecoder(resp.Body).Decode(created); err != nil {
+		return fmt.Errorf("invalid batch answer: %v", err)
+	}
+	op.Event.ID = created.Id
+	return nil
+}
@@ -0,0 +1,212 @@
+package agenda
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRequest is an operation received by the batch stub
+type batchRequest struct {
+	contentID string
+	method    string
+	path      string
+	body      map[string]interface{}
+}
+
+// batchAnswer is the answer of the batch stub to an operation. A zero status leaves the operation unanswered.
+type batchAnswer struct {
+	status int
+	body   string
+}
+
+// batchStub is a local stand-in of the batch endpoint of the calendar API.
+// answer returns the answer to the operation, given the number of times it was received.
+// A non zero status fails the whole batch request instead.
+type batchStub struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	requests []batchRequest
+	received map[string]int // method and path -> times received
+	status   int
+	answer   func(req batchRequest, times int) batchAnswer
+}
+
+func newBatchStub(t *testing.T) *batchStub {
+	stub := &batchStub{received: map[string]int{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if r.Method != "POST" || r.URL.Path != "/batch/calendar/v3" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/mixed" {
+			t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		requests := []batchRequest{}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+			if part.Header.Get("Content-Type") != "application/http" {
+				t.Errorf("unexpected part content type %q", part.Header.Get("Content-Type"))
+			}
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				t.Fatalf("invalid part: %v", err)
+			}
+			request := batchRequest{contentID: part.Header.Get("Content-Id"), method: req.Method, path: req.URL.Path}
+			if data, _ := ioutil.ReadAll(req.Body); len(data) != 0 {
+				json.Unmarshal(data, &request.body)
+			}
+			requests = append(requests, request)
+		}
+		stub.requests = append(stub.requests, requests...)
+		if stub.status != 0 {
+			w.WriteHeader(stub.status)
+			fmt.Fprint(w, `{"error":{"code":500,"message":"backend error"}}`)
+			return
+		}
+
+		writer := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		for _, request := range requests {
+			stub.received[request.method+" "+request.path]++
+			answer := stub.answer(request, stub.received[request.method+" "+request.path])
+			if answer.status == 0 {
+				continue
+			}
+			part, _ := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type": {"application/http"},
+				"Content-Id":   {"<response-" + strings.Trim(request.contentID, "<>") + ">"},
+			})
+			fmt.Fprintf(part, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+				answer.status, http.StatusText(answer.status), len(answer.body), answer.body)
+		}
+		writer.Close()
+	}))
+	return stub
+}
+
+func newBatchBackend(t *testing.T, stub *batchStub) *GoogleBackend {
+	backend, err := NewGoogleBackend(stub.server.Client(), "Europe/Paris", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.srv.BasePath = stub.server.URL + "/calendar/v3/"
+	return backend
+}
+
+func batchOperations() []Operation {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	return []Operation{
+		{Action: ActionInsert, Calendar: "events", Event: &Event{Summary: "Bootstrap", Start: start, End: start.Add(time.Hour)}},
+		{Action: ActionUpdate, Calendar: "events", Event: &Event{ID: "moved", Summary: "Kick-off", Start: start, End: start.Add(time.Hour)}},
+		{Action: ActionDelete, Calendar: "my calendar", Event: &Event{ID: "cancelled"}},
+	}
+}
+
+func TestBatchEncodesAndParsesEveryOperation(t *testing.T) {
+	defer func(delay time.Duration) { batchRetryDelay = delay }(batchRetryDelay)
+	batchRetryDelay = time.Millisecond
+	stub := newBatchStub(t)
+	defer stub.server.Close()
+	stub.answer = func(req batchRequest, times int) batchAnswer {
+		switch req.method {
+		case "POST":
+			return batchAnswer{http.StatusOK, `{"id":"created"}`}
+		case "PUT":
+			if times == 1 {
+				return batchAnswer{http.StatusServiceUnavailable, `{"error":{"code":503,"message":"backend error"}}`}
+			}
+			return batchAnswer{http.StatusOK, `{"id":"moved"}`}
+		}
+		return batchAnswer{status: http.StatusNoContent}
+	}
+
+	ops := batchOperations()
+	for i, err := range newBatchBackend(t, stub).Batch(context.Background(), ops) {
+		if err != nil {
+			t.Errorf("operation %d failed: %v", i, err)
+		}
+	}
+	if ops[0].Event.ID != "created" {
+		t.Errorf("the inserted event got the ID %q", ops[0].Event.ID)
+	}
+
+	want := []batchRequest{
+		{"<item-0>", "POST", "/calendar/v3/calendars/events/events", nil},
+		{"<item-1>", "PUT", "/calendar/v3/calendars/events/events/moved", nil},
+		{"<item-2>", "DELETE", "/calendar/v3/calendars/my calendar/events/cancelled", nil},
+		{"<item-0>", "PUT", "/calendar/v3/calendars/events/events/moved", nil}, // the temporary failure, sent again
+	}
+	if len(stub.requests) != len(want) {
+		t.Fatalf("got %d requests %+v, want %d", len(stub.requests), stub.requests, len(want))
+	}
+	for i, req := range stub.requests {
+		if req.contentID != want[i].contentID || req.method != want[i].method || req.path != want[i].path {
+			t.Errorf("request %d is %s %s %s, want %s %s %s", i, req.contentID, req.method, req.path, want[i].contentID, want[i].method, want[i].path)
+		}
+	}
+	if stub.requests[0].body["summary"] != "Bootstrap" || stub.requests[2].body != nil {
+		t.Errorf("unexpected bodies %v and %v", stub.requests[0].body, stub.requests[2].body)
+	}
+}
+
+func TestBatchRetriesOnlyDefiniteInsertFailures(t *testing.T) {
+	defer func(delay time.Duration) { batchRetryDelay = delay }(batchRetryDelay)
+	batchRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name    string
+		status  int         // status of the whole batch request
+		insert  batchAnswer // answer to the insert
+		inserts int         // times the insert is sent
+		deletes int         // times the delete is sent
+	}{
+		{"rate limited insert", 0, batchAnswer{http.StatusTooManyRequests, `{"error":{"code":429,"message":"rate limit"}}`}, batchAttempts, 1},
+		{"quota exceeded", 0, batchAnswer{http.StatusForbidden, `{"error":{"code":403,"errors":[{"reason":"rateLimitExceeded"}]}}`}, batchAttempts, 1},
+		{"forbidden insert", 0, batchAnswer{http.StatusForbidden, `{"error":{"code":403,"errors":[{"reason":"forbidden"}]}}`}, 1, 1},
+		{"insert left unanswered", 0, batchAnswer{}, 1, 1},
+		{"insert answered with garbage", 0, batchAnswer{http.StatusOK, `{"id":`}, 1, 1},
+		{"failed batch", http.StatusInternalServerError, batchAnswer{}, 1, batchAttempts},
+	}
+	for _, test := range tests {
+		stub := newBatchStub(t)
+		stub.status = test.status
+		stub.answer = func(req batchRequest, times int) batchAnswer {
+			if req.method == "POST" {
+				return test.insert
+			}
+			return batchAnswer{status: http.StatusNoContent}
+		}
+		ops := batchOperations()
+		errs := newBatchBackend(t, stub).Batch(context.Background(), []Operation{ops[0], ops[2]})
+		stub.server.Close()
+
+		inserts, deletes := 0, 0
+		for _, req := range stub.requests {
+			if req.method == "POST" {
+				inserts++
+			} else {
+				deletes++
+			}
+		}
+		if errs[0] == nil || inserts != test.inserts || deletes != test.deletes {
+			t.Errorf("%s: the insert was sent %d times and failed with %v, the delete %d times, want %d and %d",
+				test.name, inserts, errs[0], deletes, test.inserts, test.deletes)
+		}
+	}
+}
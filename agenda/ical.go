@@ -0,0 +1,385 @@
+package agenda
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const icalProductID = "-//nheuillet//calendar-linker//EN"
+
+// icalLinkerProperty is the non standard property holding the linker properties of an event, encoded as a query string.
+const icalLinkerProperty = "X-CALENDAR-LINKER"
+
+const (
+	icalDateTime    = "20060102T150405"
+	icalUTCDateTime = "20060102T150405Z"
+	icalDate        = "20060102"
+)
+
+// icalWriter builds iCalendar (RFC 5545) content
+type icalWriter struct {
+	b strings.Builder
+}
+
+// line writes a content line, folded at 75 octets as required by the RFC.
+func (w *icalWriter) line(name string, value string) {
+	content := name + ":" + value
+	for len(content) > 75 {
+		cut := 75
+		for cut > 0 && content[cut]&0xC0 == 0x80 { // do not split an utf-8 character
+			cut--
+		}
+		w.b.WriteString(content[:cut] + "\r\n")
+		content = " " + content[cut:]
+	}
+	w.b.WriteString(content + "\r\n")
+}
+
+func (w *icalWriter) String() string {
+	return w.b.String()
+}
+
+// escapeText escapes a TEXT value
+func escapeText(text string) string {
+	text = strings.Replace(text, "\\", "\\\\", -1)
+	text = strings.Replace(text, ";", "\\;", -1)
+	text = strings.Replace(text, ",", "\\,", -1)
+	text = strings.Replace(text, "\r\n", "\\n", -1)
+	return strings.Replace(text, "\n", "\\n", -1)
+}
+
+// unescapeText reverts escapeText
+func unescapeText(text string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i == len(text)-1 {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+// formatOffset formats an UTC offset in seconds as +HHMM
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// timezoneTransitions returns the instants of the year where the offset of the location changes.
+func timezoneTransitions(loc *time.Location, year int) []time.Time {
+	transitions := []time.Time{}
+	current := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+1, 1, 1, 0, 0, 0, 0, loc)
+
+	for current.Before(end) {
+		next := current.Add(time.Hour)
+		_, offset := current.Zone()
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			low, high := current, next
+			for high.Sub(low) > time.Minute { // narrow down to the minute
+				middle := low.Add(high.Sub(low) / 2)
+				if _, middleOffset := middle.Zone(); middleOffset == offset {
+					low = middle
+				} else {
+					high = middle
+				}
+			}
+			transitions = append(transitions, high.Truncate(time.Minute))
+		}
+		current = next
+	}
+	return transitions
+}
+
+// yearlyRule describes the day of a transition as a yearly recurrence rule, eg: last sunday of march.
+func yearlyRule(t time.Time) string {
+	days := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	week := fmt.Sprintf("%d", (t.Day()-1)/7+1)
+
+	if t.Day()+7 > daysInMonth {
+		week = "-1"
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%s%s", int(t.Month()), week, days[t.Weekday()])
+}
+
+// vtimezone writes the VTIMEZONE component of the location. The daylight saving rules
+// are computed from the transitions of the given year.
+func (w *icalWriter) vtimezone(loc *time.Location, year int) {
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", loc.String())
+
+	transitions := timezoneTransitions(loc, year)
+	if len(transitions) == 0 {
+		name, offset := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+		w.line("BEGIN", "STANDARD")
+		w.line("DTSTART", "19700101T000000")
+		w.line("TZOFFSETFROM", formatOffset(offset))
+		w.line("TZOFFSETTO", formatOffset(offset))
+		w.line("TZNAME", name)
+		w.line("END", "STANDARD")
+	}
+	for _, transition := range transitions {
+		_, offsetFrom := transition.Add(-time.Minute).Zone()
+		name, offsetTo := transition.Zone()
+		component := "STANDARD"
+		if offsetTo > offsetFrom {
+			component = "DAYLIGHT"
+		}
+		onset := transition.UTC().Add(time.Duration(offsetFrom) * time.Second) // local time before the transition
+		w.line("BEGIN", component)
+		w.line("DTSTART", onset.Format(icalDateTime))
+		w.line("RRULE", yearlyRule(onset))
+		w.line("TZOFFSETFROM", formatOffset(offsetFrom))
+		w.line("TZOFFSETTO", formatOffset(offsetTo))
+		w.line("TZNAME", name)
+		w.line("END", component)
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+// dateTime writes a date time property in the location. UTC times use the UTC form and need no VTIMEZONE.
+func (w *icalWriter) dateTime(name string, t time.Time, loc *time.Location) {
+	if loc == time.UTC {
+		w.line(name, t.UTC().Format(icalUTCDateTime))
+		return
+	}
+	w.line(name+";TZID="+loc.String(), t.In(loc).Format(icalDateTime))
+}
+
+// eventUID derives a stable UID for the event from the intra codes stored in its linker properties.
+func eventUID(ev *Event) string {
+	identity := strings.Replace(eventIdentity(ev.Properties), "/", "-", -1)
+	return identity + "@calendar-linker"
+}
+
+// vevent writes the VEVENT component of the event, with a VALARM per reminder.
+func (w *icalWriter) vevent(ev *Event, uid string, loc *time.Location) {
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", uid)
+	w.line("DTSTAMP", time.Now().UTC().Format(icalUTCDateTime))
+	w.dateTime("DTSTART", ev.Start, loc)
+	w.dateTime("DTEND", ev.End, loc)
+	w.line("SUMMARY", escapeText(ev.Summary))
+	if ev.Description != "" {
+		w.line("DESCRIPTION", escapeText(ev.Description))
+	}
+	if ev.Location != "" {
+		w.line("LOCATION", escapeText(ev.Location))
+	}
+	for _, at := range ev.Attendees {
+		w.line(fmt.Sprintf("ATTENDEE;CN=\"%s\"", strings.Replace(at.Name, "\"", "'", -1)), "mailto:"+at.Email)
+	}
+	if ev.Properties != nil {
+		properties := url.Values{}
+		for key, val := range ev.Properties {
+			properties.Set(key, val)
+		}
+		w.line(icalLinkerProperty, properties.Encode())
+	}
+	for _, minutes := range ev.Reminders {
+		w.line("BEGIN", "VALARM")
+		w.line("ACTION", "DISPLAY")
+		w.line("DESCRIPTION", escapeText(ev.Summary))
+		w.line("TRIGGER", fmt.Sprintf("-PT%dM", minutes))
+		w.line("END", "VALARM")
+	}
+	w.line("END", "VEVENT")
+}
+
+// icalCalendar builds a VCALENDAR holding a single event, as stored in a CalDAV resource.
+func icalCalendar(ev *Event, uid string, loc *time.Location) string {
+	w := &icalWriter{}
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", icalProductID)
+	if loc != time.UTC {
+		w.vtimezone(loc, ev.Start.Year())
+	}
+	w.vevent(ev, uid, loc)
+	w.line("END", "VCALENDAR")
+	return w.String()
+}
+
+// icalLine is a parsed content line
+type icalLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldLines splits the iCalendar content in content lines
+func unfoldLines(data string) []string {
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseLine splits a content line in its name, parameters and value
+func parseLine(line string) icalLine {
+	parsed := icalLine{params: map[string]string{}}
+	quoted := false
+	sep := -1
+
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			sep = i
+			break
+		}
+	}
+	if sep == -1 {
+		parsed.name = strings.ToUpper(line)
+		return parsed
+	}
+	parsed.value = line[sep+1:]
+	parts := strings.Split(line[:sep], ";")
+	parsed.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		keyVal := strings.SplitN(param, "=", 2)
+		if len(keyVal) == 2 {
+			parsed.params[strings.ToUpper(keyVal[0])] = strings.Trim(keyVal[1], "\"")
+		}
+	}
+	return parsed
+}
+
+// parseICalTime parses a DATE or DATE-TIME value. Floating times are read in the default location.
+func parseICalTime(line icalLine, defaultLoc *time.Location) (time.Time, error) {
+	loc := defaultLoc
+	if tzid, ok := line.params["TZID"]; ok {
+		if tzLoc, err := time.LoadLocation(tzid); err == nil {
+			loc = tzLoc
+		}
+	}
+	switch {
+	case line.params["VALUE"] == "DATE" || len(line.value) == len(icalDate):
+		return time.ParseInLocation(icalDate, line.value, loc)
+	case strings.HasSuffix(line.value, "Z"):
+		return time.Parse(icalUTCDateTime, line.value)
+	default:
+		return time.ParseInLocation(icalDateTime, line.value, loc)
+	}
+}
+
+// parseTrigger reads the minutes of a relative VALARM trigger such as -PT10M or -P1DT2H.
+func parseTrigger(value string) (int, bool) {
+	if !strings.HasPrefix(value, "-P") {
+		return 0, false
+	}
+	minutes := 0
+	number := 0
+	for _, c := range value[2:] {
+		switch {
+		case c >= '0' && c <= '9':
+			number = number*10 + int(c-'0')
+		case c == 'W':
+			minutes += number * 7 * 24 * 60
+			number = 0
+		case c == 'D':
+			minutes += number * 24 * 60
+			number = 0
+		case c == 'H':
+			minutes += number * 60
+			number = 0
+		case c == 'M':
+			minutes += number
+			number = 0
+		case c == 'S':
+			minutes += number / 60
+			number = 0
+		}
+	}
+	return minutes, true
+}
+
+// parseVEvents extracts the events of iCalendar content, along with their UID.
+func parseVEvents(data string, defaultLoc *time.Location) ([]*Event, []string) {
+	events := []*Event{}
+	uids := []string{}
+	var current *Event
+	var uid string
+	depth := 0 // components nested in the VEVENT, such as VALARM
+
+	for _, raw := range unfoldLines(data) {
+		line := parseLine(raw)
+		switch {
+		case line.name == "BEGIN" && line.value == "VEVENT":
+			current = &Event{}
+			uid = ""
+		case current == nil:
+			continue
+		case line.name == "BEGIN":
+			depth++
+		case line.name == "END" && line.value == "VEVENT":
+			events = append(events, current)
+			uids = append(uids, uid)
+			current = nil
+		case line.name == "END":
+			depth--
+		case depth > 0:
+			if line.name == "TRIGGER" {
+				if minutes, ok := parseTrigger(line.value); ok {
+					current.Reminders = append(current.Reminders, minutes)
+				}
+			}
+		case line.name == "UID":
+			uid = line.value
+		case line.name == "SUMMARY":
+			current.Summary = unescapeText(line.value)
+		case line.name == "DESCRIPTION":
+			current.Description = unescapeText(line.value)
+		case line.name == "LOCATION":
+			current.Location = unescapeText(line.value)
+		case line.name == "DTSTART":
+			current.Start, _ = parseICalTime(line, defaultLoc)
+		case line.name == "DTEND":
+			current.End, _ = parseICalTime(line, defaultLoc)
+		case line.name == "ATTENDEE":
+			current.Attendees = append(current.Attendees, Attendee{
+				Email: strings.TrimPrefix(strings.TrimPrefix(line.value, "mailto:"), "MAILTO:"),
+				Name:  line.params["CN"],
+			})
+		case line.name == icalLinkerProperty:
+			properties, err := url.ParseQuery(line.value)
+			if err != nil {
+				continue
+			}
+			current.Properties = map[string]string{}
+			for key := range properties {
+				current.Properties[key] = properties.Get(key)
+			}
+		}
+	}
+	return events, uids
+}
@@ -0,0 +1,52 @@
+package agenda
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/intra"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// WriteICS writes the events and the projects as an iCalendar (RFC 5545) file named name.
+// Either of them can be nil in order to export them in separate files.
+func WriteICS(w io.Writer, config *parser.Config, name string, events []intra.Event, projects []intra.Activity) error {
+	loc, err := intra.CampusLocation(config)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %v", err)
+	}
+	ical := &icalWriter{}
+
+	ical.line("BEGIN", "VCALENDAR")
+	ical.line("VERSION", "2.0")
+	ical.line("PRODID", icalProductID)
+	ical.line("CALSCALE", "GREGORIAN")
+	ical.line("METHOD", "PUBLISH")
+	ical.line("X-WR-CALNAME", escapeText(name))
+	if loc != time.UTC {
+		ical.line("X-WR-TIMEZONE", loc.String())
+		ical.vtimezone(loc, time.Now().Year())
+	}
+	for _, ev := range events {
+		newEvent, err := newClassEvent(config, ev, loc)
+		if err != nil {
+			log.Printf("Skipping the event %q of the intra: %v\n", ev.ActiTitle, err)
+			continue
+		}
+		ical.vevent(newEvent, eventUID(newEvent), loc)
+	}
+	for _, ev := range projects {
+		newEvent, err := newProjectEvent(config, ev, loc)
+		if err != nil {
+			log.Printf("Skipping the project %q of the intra: %v\n", ev.Title, err)
+			continue
+		}
+		ical.vevent(newEvent, eventUID(newEvent), loc)
+	}
+	ical.line("END", "VCALENDAR")
+
+	_, err = io.WriteString(w, ical.String())
+	return err
+}
@@ -0,0 +1,22 @@
+package agenda
+
+import (
+	"context"
+	"log"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a context the agenda functions log to logger with, in order to tell apart the logs of the
+// profiles synchronised at the same time. Without it, they log with the standard logger.
+func WithLogger(ctx context.Context, logger *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger of ctx, see WithLogger
+func Logger(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Logger); ok {
+		return logger
+	}
+	return log.New(log.Writer(), log.Prefix(), log.Flags())
+}
@@ -0,0 +1,97 @@
+package agenda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is a calendar backend keeping the events in memory.
+// It allows running the synchronisation logic without any real calendar.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	calendars map[string][]*Event
+	lastID    int
+}
+
+// NewMemoryBackend creates an empty in-memory calendar backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{calendars: map[string][]*Event{}}
+}
+
+// copyEvent returns a copy of the event so that callers can't modify the stored one.
+func copyEvent(ev *Event) *Event {
+	newEvent := *ev
+	if ev.Reminders != nil {
+		newEvent.Reminders = append([]int{}, ev.Reminders...)
+	}
+	newEvent.Attendees = append([]Attendee(nil), ev.Attendees...)
+	if ev.Properties != nil {
+		newEvent.Properties = map[string]string{}
+		for key, val := range ev.Properties {
+			newEvent.Properties[key] = val
+		}
+	}
+	return &newEvent
+}
+
+// ListEvents lists the events of the calendar ending after from and starting before to, ordered by start time
+func (m *MemoryBackend) ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []*Event{}
+	for _, ev := range m.calendars[calendarID] {
+		if ev.End.After(from) && (to.IsZero() || ev.Start.Before(to)) {
+			events = append(events, copyEvent(ev))
+		}
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+// Insert stores the event and gives it a new ID
+func (m *MemoryBackend) Insert(ctx context.Context, calendarID string, ev *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	ev.ID = fmt.Sprintf("memory-%d", m.lastID)
+	m.calendars[calendarID] = append(m.calendars[calendarID], copyEvent(ev))
+	return nil
+}
+
+// Update replaces the stored event having the same ID
+func (m *MemoryBackend) Update(ctx context.Context, calendarID string, ev *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for index, stored := range m.calendars[calendarID] {
+		if stored.ID == ev.ID {
+			m.calendars[calendarID][index] = copyEvent(ev)
+			return nil
+		}
+	}
+	return fmt.Errorf("event %s not found in calendar %s", ev.ID, calendarID)
+}
+
+// Delete removes the stored event having the same ID
+func (m *MemoryBackend) Delete(ctx context.Context, calendarID string, ev *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.calendars[calendarID]
+	for index, stored := range events {
+		if stored.ID == ev.ID {
+			m.calendars[calendarID] = append(events[:index], events[index+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("event %s not found in calendar %s", ev.ID, calendarID)
+}
+
+// Owned tells if the event carries the linker properties
+func (m *MemoryBackend) Owned(ev *Event) bool {
+	return ev.Properties[propertyLinker] == linkerMarker
+}
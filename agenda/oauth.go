@@ -0,0 +1,240 @@
+package agenda
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loginTimeout bounds the wait for the user to log in in the browser
+const loginTimeout = 5 * time.Minute
+
+// randomString returns size random bytes encoded as base64url, as used by the state and the PKCE verifier
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sshSession tells if the linker runs over SSH, where the browser of the user can't reach the loopback listener
+// without a port forwarding
+func sshSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+}
+
+// loopbackLogin is an authorization code flow with a loopback redirect: the browser of the user is sent back
+// to the linker, with the code. The code is bound to the login by a random state, and to the linker by PKCE,
+// so that an intercepted code can't be exchanged by anyone else.
+type loopbackLogin struct {
+	config   oauth2.Config // with the loopback redirect
+	state    string
+	verifier string
+	codes    chan string
+	failures chan error
+	done     chan struct{} // closed when the login is over
+}
+
+// newLoopbackLogin starts a login redirected to redirectURL
+func newLoopbackLogin(config *oauth2.Config, redirectURL string) (*loopbackLogin, error) {
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	login := &loopbackLogin{config: *config, state: state, verifier: verifier,
+		codes: make(chan string, 1), failures: make(chan error, 1), done: make(chan struct{})}
+	login.config.RedirectURL = redirectURL
+	return login, nil
+}
+
+// authURL returns the link the user opens to log in
+func (l *loopbackLogin) authURL() string {
+	challenge := sha256.Sum256([]byte(l.verifier))
+	return l.config.AuthCodeURL(l.state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// callback handles the query of the redirect of the browser. It fails when the redirect is not the one of this login,
+// or when it carries no code. A login refused by the user is passed on to wait.
+func (l *loopbackLogin) callback(query url.Values) error {
+	if query.Get("state") != l.state {
+		return errors.New("not the redirect of this login")
+	}
+	if reason := query.Get("error"); reason != "" {
+		err := fmt.Errorf("login failed: %s %s", reason, query.Get("error_description"))
+		select {
+		case l.failures <- err:
+		default:
+		}
+		return err
+	}
+	if query.Get("code") == "" {
+		return errors.New("missing authorization code")
+	}
+	select {
+	case l.codes <- query.Get("code"):
+	default:
+	}
+	return nil
+}
+
+// ServeHTTP receives the redirect of the browser on the loopback listener
+func (l *loopbackLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" || r.URL.Query().Get("state") != l.state { // not the redirect of this login
+		http.NotFound(w, r)
+		return
+	}
+	if err := l.callback(r.URL.Query()); err != nil {
+		if r.URL.Query().Get("error") != "" {
+			fmt.Fprintln(w, "Login failed, you can close this page.")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "Logged in, you can close this page.")
+}
+
+// readRedirects reads the redirect URLs pasted by the user, one per line, until one of them is the redirect of this login
+// or the login is over. This is how the user logs in when the browser can't reach the loopback listener.
+func (l *loopbackLogin) readRedirects(input io.Reader) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		redirect, err := url.Parse(line)
+		if err == nil {
+			err = l.callback(redirect.Query())
+			if err == nil || redirect.Query().Get("error") != "" {
+				return
+			}
+		}
+		fmt.Printf("Invalid redirect URL (%v), paste the whole address of the page the browser was sent to\n", err)
+	}
+}
+
+// wait waits for the code of the redirect, then exchanges it for a token
+func (l *loopbackLogin) wait(ctx context.Context) (*oauth2.Token, error) {
+	defer close(l.done)
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	select {
+	case code := <-l.codes:
+		tok, err := l.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", l.verifier))
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+		}
+		return tok, nil
+	case err := <-l.failures:
+		return nil, err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New("login timed out, please try again")
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// getTokenFromLoopback runs a loopback login redirected to listener. Over SSH, the user either forwards the port
+// of the listener, or pastes the redirect URL the browser failed to load.
+func getTokenFromLoopback(ctx context.Context, config *oauth2.Config, listener net.Listener) (*oauth2.Token, error) {
+	login, err := newLoopbackLogin(config, "http://"+listener.Addr().String()+"/")
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: login}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Printf("Go to the following link in your browser to log in:\n%v\n", login.authURL())
+	if !sshSession() {
+		return login.wait(ctx)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	fmt.Printf("SSH session detected: forward the port of the linker with `ssh -L %s:127.0.0.1:%s <host>` before opening the link,\n"+
+		"or paste here the address of the page your browser failed to load after logging in:\n", port, port)
+	pasted := make(chan struct{})
+	go func() {
+		login.readRedirects(os.Stdin)
+		close(pasted)
+	}()
+	tok, err := login.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-pasted:
+	default: // logged in through the forwarded port, the input is still read
+		fmt.Println("Logged in, press Enter to continue")
+		select {
+		case <-pasted:
+		case <-ctx.Done():
+		}
+	}
+	return tok, nil
+}
+
+// persistentTokenSource saves the tokens refreshed by source, so that the next run starts from the latest one
+type persistentTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	tokens *tokenStorage
+	saved  string // the access token saved last
+}
+
+// Token returns the token of the source, saving it when it was refreshed. A token that can't be saved is still used.
+func (s *persistentTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.saved {
+		if err := s.tokens.write(tok); err != nil {
+			log.Printf("Unable to save the refreshed oauth token: %v\n", err)
+		} else {
+			s.saved = tok.AccessToken
+		}
+	}
+	return tok, nil
+}
+
+// persistentClient returns a client authenticated with tok, which saves the token to tokens whenever it is refreshed.
+// The client outlives the login context, it refreshes the token when it expires.
+func persistentClient(config *oauth2.Config, tok *oauth2.Token, tokens *tokenStorage) *http.Client {
+	source := &persistentTokenSource{
+		source: config.TokenSource(context.Background(), tok),
+		tokens: tokens,
+		saved:  tok.AccessToken,
+	}
+	return oauth2.NewClient(context.Background(), source)
+}
@@ -0,0 +1,142 @@
+package agenda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newLoginStub returns a token endpoint exchanging the code "granted", checking the PKCE verifier against the
+// challenge of the login, and the login redirected to it
+func newLoginStub(t *testing.T) (*httptest.Server, *loopbackLogin, url.Values) {
+	var challenge string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "granted" || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge ||
+			r.Form.Get("redirect_uri") != "http://127.0.0.1:8085/" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+	}))
+	config := &oauth2.Config{ClientID: "linker", Endpoint: oauth2.Endpoint{AuthURL: server.URL + "/auth", TokenURL: server.URL + "/token"}}
+	login, err := newLoopbackLogin(config, "http://127.0.0.1:8085/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(login.authURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") == "" || query.Get("redirect_uri") != "http://127.0.0.1:8085/" {
+		t.Fatalf("unexpected login link %s", authURL)
+	}
+	challenge = query.Get("code_challenge")
+	return server, login, query
+}
+
+func TestLoopbackCallback(t *testing.T) {
+	server, login, auth := newLoginStub(t)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"other path", "/favicon.ico?state=" + auth.Get("state") + "&code=granted", http.StatusNotFound},
+		{"missing state", "/?code=stolen", http.StatusNotFound},
+		{"wrong state", "/?state=forged&code=stolen", http.StatusNotFound},
+		{"missing code", "/?state=" + auth.Get("state"), http.StatusBadRequest},
+		{"redirect of the login", "/?state=" + auth.Get("state") + "&code=granted", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		login.ServeHTTP(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+	tok, err := login.wait(context.Background())
+	if err != nil || tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Fatalf("got %+v, %v, want the exchanged token", tok, err)
+	}
+}
+
+func TestLoopbackRefusedLogin(t *testing.T) {
+	server, login, auth := newLoginStub(t)
+	defer server.Close()
+
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, httptest.NewRequest("GET", "/?state="+auth.Get("state")+"&error=access_denied", nil))
+	if _, err := login.wait(context.Background()); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("got %v, want the refusal of the user", err)
+	}
+}
+
+func TestPastedRedirect(t *testing.T) {
+	server, login, auth := newLoginStub(t)
+	defer server.Close()
+
+	// over SSH, the browser fails to load the redirect and the user pastes its address
+	login.readRedirects(strings.NewReader("\nnot a redirect\nhttp://127.0.0.1:8085/?state=forged&code=stolen\n" +
+		"http://127.0.0.1:8085/?state=" + auth.Get("state") + "&code=granted&scope=calendar\n"))
+	tok, err := login.wait(context.Background())
+	if err != nil || tok.AccessToken != "access" {
+		t.Fatalf("got %+v, %v, want the exchanged token", tok, err)
+	}
+}
+
+// tokenSequence is a token source returning its tokens in turn, the last one forever
+type tokenSequence []*oauth2.Token
+
+func (s *tokenSequence) Token() (*oauth2.Token, error) {
+	tok := (*s)[0]
+	if len(*s) > 1 {
+		*s = (*s)[1:]
+	}
+	return tok, nil
+}
+
+func TestPersistentTokenSourceSavesRefreshedTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	source := &persistentTokenSource{
+		source: &tokenSequence{{AccessToken: "first"}, {AccessToken: "first"}, {AccessToken: "refreshed", RefreshToken: "refresh"}},
+		tokens: &tokenStorage{file: path},
+		saved:  "first",
+	}
+
+	for i := 0; i < 2; i++ {
+		if tok, err := source.Token(); err != nil || tok.AccessToken != "first" {
+			t.Fatalf("got %+v, %v, want the first token", tok, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the token was saved before being refreshed: %v", err)
+	}
+	if tok, err := source.Token(); err != nil || tok.AccessToken != "refreshed" {
+		t.Fatalf("got %+v, %v, want the refreshed token", tok, err)
+	}
+	saved, err := tokenFromFile(path)
+	if err != nil || saved.AccessToken != "refreshed" || saved.RefreshToken != "refresh" {
+		t.Errorf("saved %+v, %v, want the refreshed token", saved, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the token file has the mode %v (%v), want 0600", info.Mode(), err)
+	}
+}
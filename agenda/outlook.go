@@ -0,0 +1,547 @@
+package agenda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+	"golang.org/x/oauth2"
+)
+
+const (
+	graphURL      = "https://graph.microsoft.com/v1.0"
+	microsoftURL  = "https://login.microsoftonline.com"
+	outlookScopes = "offline_access Calendars.ReadWrite"
+	graphTimeout  = 30
+	graphDateTime = "2006-01-02T15:04:05"
+)
+
+// Delays of the device code polling. They are variables so that the tests don't wait.
+var (
+	devicePollInterval = 5 * time.Second // used when the endpoint gives no interval
+	deviceSlowDown     = 5 * time.Second // added to the interval when the endpoint asks to slow down
+)
+
+// outlookParticipants introduces the participants of a project in the body of its outlook event.
+// They are not sent as attendees, as outlook would invite every one of them on every change.
+const outlookParticipants = "Participants:"
+
+// outlookPropertyID is the id of the single value extended property holding the linker properties
+// of an event, encoded as a query string. The GUID is the property set of the linker.
+const outlookPropertyID = "String {7b3f0c8e-5a1d-4c2b-9e6f-3d8a1b2c4e5f} Name calendarLinker"
+
+// deviceCode is the answer of the device code endpoint
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	VerificationURL string `json:"verification_url"` // google names it so
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Message         string `json:"message"`
+}
+
+// deviceToken is the answer of the token endpoint while polling for the device code
+type deviceToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// outlookOAuthConfig returns the oauth configuration of the Microsoft identity platform for the tenant
+func outlookOAuthConfig(clientID string, tenant string, loginURL string) *oauth2.Config {
+	if tenant == "" {
+		tenant = "common"
+	}
+	return &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  loginURL + "/" + tenant + "/oauth2/v2.0/authorize",
+			TokenURL: loginURL + "/" + tenant + "/oauth2/v2.0/token",
+		},
+		Scopes: strings.Split(outlookScopes, " "),
+	}
+}
+
+// postForm sends the form to the url and decodes the JSON answer, whatever the status code.
+func postForm(ctx context.Context, client *http.Client, target string, form url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// outlookDeviceURL returns the device code endpoint of the Microsoft identity platform, alongside its token endpoint
+func outlookDeviceURL(config *oauth2.Config) string {
+	return strings.TrimSuffix(config.Endpoint.TokenURL, "/token") + "/devicecode"
+}
+
+// getTokenFromDevice runs the OAuth device code flow: the user opens the verification url on any device
+// and types the code while the token endpoint is polled. deviceURL is the device code endpoint of the provider.
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, deviceURL string) (*oauth2.Token, error) {
+	client := &http.Client{Timeout: graphTimeout * time.Second}
+	code := &deviceCode{}
+
+	err := postForm(ctx, client, deviceURL, url.Values{
+		"client_id": {config.ClientID},
+		"scope":     {strings.Join(config.Scopes, " ")},
+	}, code)
+	if err != nil {
+		return nil, err
+	}
+	if code.DeviceCode == "" {
+		return nil, errors.New("no device code returned by " + deviceURL)
+	}
+	if code.VerificationURI == "" {
+		code.VerificationURI = code.VerificationURL
+	}
+	if code.Message != "" {
+		fmt.Println(code.Message)
+	} else {
+		fmt.Printf("Go to %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	}
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval == 0 {
+		interval = devicePollInterval
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		tok := &deviceToken{}
+		form := url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"client_id":   {config.ClientID},
+			"device_code": {code.DeviceCode},
+		}
+		if config.ClientSecret != "" { // google asks for the secret of its clients, microsoft public clients have none
+			form.Set("client_secret", config.ClientSecret)
+		}
+		err := postForm(ctx, client, config.Endpoint.TokenURL, form, tok)
+		if err != nil {
+			return nil, err
+		}
+		switch tok.Error {
+		case "":
+			return &oauth2.Token{
+				AccessToken:  tok.AccessToken,
+				RefreshToken: tok.RefreshToken,
+				TokenType:    tok.TokenType,
+				Expiry:       time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second),
+			}, nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += deviceSlowDown
+		default:
+			return nil, fmt.Errorf("device login failed: %s %s", tok.Error, tok.Description)
+		}
+	}
+	return nil, errors.New("device login expired, please try again")
+}
+
+// outlookTokenFile returns the path of the outlook token, stored alongside the google one at tokenFile.
+func outlookTokenFile(tokenFile string) string {
+	return filepath.Join(filepath.Dir(tokenFile), "outlook_token.json")
+}
+
+// GetOutlookClient returns an http client authenticated on the Microsoft Graph API with the app of the config.
+// The token is read from the outlook_token.json alongside the token_file of the config, or from the secret store,
+// or obtained with the device code flow the first time.
+func GetOutlookClient(ctx context.Context, conf *parser.Config, loginURL string) (*http.Client, error) {
+	config := outlookOAuthConfig(conf.OutlookClientID, conf.OutlookTenant, loginURL)
+	tokens, err := newTokenStorage(conf, outlookTokenFile(conf.TokenFile))
+	if err != nil {
+		return nil, err
+	}
+	tok, err := tokens.load()
+	if err != nil {
+		tok, err = getTokenFromDevice(ctx, config, outlookDeviceURL(config))
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve token from device login: %v", err)
+		}
+		if err := tokens.save(tok); err != nil {
+			return nil, err
+		}
+	}
+	return persistentClient(config, tok, tokens), nil
+}
+
+type graphDate struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphEmail struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+}
+
+type graphAttendee struct {
+	EmailAddress graphEmail `json:"emailAddress"`
+	Type         string     `json:"type"`
+}
+
+type graphProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+// graphEvent is the event resource of the Microsoft Graph API
+type graphEvent struct {
+	ID                 string          `json:"id,omitempty"`
+	Subject            string          `json:"subject"`
+	Body               graphBody       `json:"body"`
+	Start              graphDate       `json:"start"`
+	End                graphDate       `json:"end"`
+	Location           graphLocation   `json:"location"`
+	Categories         []string        `json:"categories"`
+	IsReminderOn       bool            `json:"isReminderOn"`
+	ReminderMinutes    int             `json:"reminderMinutesBeforeStart"`
+	Attendees          []graphAttendee `json:"attendees,omitempty"` // only read, the linker lists the participants in the body
+	ExtendedProperties []graphProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+type graphEvents struct {
+	Value    []graphEvent `json:"value"`
+	NextLink string       `json:"@odata.nextLink"`
+}
+
+type graphCalendar struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type graphCalendars struct {
+	Value    []graphCalendar `json:"value"`
+	NextLink string          `json:"@odata.nextLink"`
+}
+
+// graphError is the error body returned by the Microsoft Graph API
+type graphError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// OutlookBackend is the calendar backend storing the events in Outlook through the Microsoft Graph API.
+// Outlook has a single reminder per event: the first one of reminder_time is used.
+// event_color and project_color are the names of the Outlook categories given to the events.
+// The participants of the projects are listed in the body of their event rather than invited.
+type OutlookBackend struct {
+	client   *http.Client
+	baseURL  string
+	timezone string
+
+	mu        sync.Mutex
+	calendars map[string]string // calendar id from the config file -> graph calendar path
+}
+
+// NewOutlookBackend creates a backend using the authenticated client on the Graph API at baseURL.
+// Events are created in the given timezone.
+func NewOutlookBackend(client *http.Client, baseURL string, timezone string) *OutlookBackend {
+	return &OutlookBackend{
+		client:    client,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		timezone:  timezone,
+		calendars: map[string]string{},
+	}
+}
+
+// do executes a request on the Graph API, encoding the body and decoding the answer as JSON.
+func (o *OutlookBackend) do(ctx context.Context, method string, target string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	if !strings.HasPrefix(target, "http") {
+		target = o.baseURL + target
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", `outlook.timezone="UTC", outlook.body-content-type="text"`)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		graphErr := &graphError{}
+		json.NewDecoder(resp.Body).Decode(graphErr)
+		return &CalendarError{
+			Reason: statusReason(resp.StatusCode, strings.HasPrefix(target, o.baseURL+"/me/calendars/")),
+			Err:    fmt.Errorf("graph %s %s: %s %s", method, target, resp.Status, graphErr.Error.Message),
+		}
+	}
+	if result == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// calendarPath returns the graph path of the calendar for a calendar id of the config file.
+// "primary" is the default calendar, other ids are either calendar names or graph calendar ids.
+func (o *OutlookBackend) calendarPath(ctx context.Context, calendarID string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if calendarPath, ok := o.calendars[calendarID]; ok {
+		return calendarPath, nil
+	}
+	if calendarID == "" || strings.EqualFold(calendarID, "primary") {
+		o.calendars[calendarID] = "/me/calendar"
+		return "/me/calendar", nil
+	}
+	calendarPath := "/me/calendars/" + url.PathEscape(calendarID)
+	for target := "/me/calendars?$select=id,name&$top=100"; target != ""; {
+		page := &graphCalendars{}
+		if err := o.do(ctx, "GET", target, nil, page); err != nil {
+			return "", err
+		}
+		target = page.NextLink
+		for _, cal := range page.Value {
+			if cal.Name == calendarID {
+				calendarPath = "/me/calendars/" + url.PathEscape(cal.ID)
+				target = ""
+				break
+			}
+		}
+	}
+	o.calendars[calendarID] = calendarPath
+	return calendarPath, nil
+}
+
+// participantsBody appends the participants to the description, one per line
+func participantsBody(description string, attendees []Attendee) string {
+	if len(attendees) == 0 {
+		return description
+	}
+	lines := []string{outlookParticipants}
+	for _, at := range attendees {
+		lines = append(lines, "- "+at.Name+" <"+at.Email+">")
+	}
+	if description != "" {
+		description += "\n\n"
+	}
+	return description + strings.Join(lines, "\n")
+}
+
+// splitParticipants reverts participantsBody: it returns the description and the participants of the body
+func splitParticipants(body string) (string, []Attendee) {
+	body = strings.TrimSpace(normalizeNewlines(body))
+	index := strings.LastIndex(body, "\n\n"+outlookParticipants+"\n")
+	if strings.HasPrefix(body, outlookParticipants+"\n") {
+		index = 0
+	}
+	if index < 0 {
+		return body, nil
+	}
+	description, list := body[:index], strings.TrimSpace(body[index:])
+	attendees := []Attendee{}
+	for _, line := range strings.Split(list, "\n")[1:] {
+		line = strings.TrimPrefix(line, "- ")
+		open := strings.LastIndex(line, " <")
+		if open < 0 || !strings.HasSuffix(line, ">") {
+			return body, nil // not written by the linker
+		}
+		attendees = append(attendees, Attendee{Name: line[:open], Email: line[open+2 : len(line)-1]})
+	}
+	return description, attendees
+}
+
+func fromGraphEvent(gEv *graphEvent) *Event {
+	description, attendees := splitParticipants(gEv.Body.Content)
+	ev := &Event{
+		ID:          gEv.ID,
+		Summary:     gEv.Subject,
+		Description: description,
+		Location:    gEv.Location.DisplayName,
+		Attendees:   attendees,
+	}
+	ev.Start, _ = time.Parse(graphDateTime, strings.Split(gEv.Start.DateTime, ".")[0]) // dates are requested in UTC
+	ev.End, _ = time.Parse(graphDateTime, strings.Split(gEv.End.DateTime, ".")[0])
+	if len(gEv.Categories) > 0 {
+		ev.Color = gEv.Categories[0]
+	}
+	if gEv.IsReminderOn {
+		ev.Reminders = []int{gEv.ReminderMinutes}
+	}
+	for _, at := range gEv.Attendees { // set on the event itself rather than listed in the body
+		ev.Attendees = append(ev.Attendees, Attendee{Email: at.EmailAddress.Address, Name: at.EmailAddress.Name})
+	}
+	for _, property := range gEv.ExtendedProperties {
+		if !strings.EqualFold(property.ID, outlookPropertyID) {
+			continue
+		}
+		values, err := url.ParseQuery(property.Value)
+		if err != nil {
+			continue
+		}
+		ev.Properties = map[string]string{}
+		for key := range values {
+			ev.Properties[key] = values.Get(key)
+		}
+	}
+	return ev
+}
+
+func (o *OutlookBackend) toGraphEvent(ev *Event) *graphEvent {
+	loc, err := time.LoadLocation(o.timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	gEv := &graphEvent{
+		Subject:    ev.Summary,
+		Body:       graphBody{ContentType: "text", Content: participantsBody(ev.Description, ev.Attendees)},
+		Start:      graphDate{DateTime: ev.Start.In(loc).Format(graphDateTime), TimeZone: loc.String()},
+		End:        graphDate{DateTime: ev.End.In(loc).Format(graphDateTime), TimeZone: loc.String()},
+		Location:   graphLocation{DisplayName: ev.Location},
+		Categories: []string{},
+	}
+	if ev.Color != "" {
+		gEv.Categories = append(gEv.Categories, ev.Color)
+	}
+	if len(ev.Reminders) > 0 {
+		gEv.IsReminderOn = true
+		gEv.ReminderMinutes = ev.Reminders[0]
+	}
+	if ev.Properties != nil {
+		properties := url.Values{}
+		for key, val := range ev.Properties {
+			properties.Set(key, val)
+		}
+		gEv.ExtendedProperties = []graphProperty{{ID: outlookPropertyID, Value: properties.Encode()}}
+	}
+	return gEv
+}
+
+// graphMaxWindow is the longest range listed by a single calendar view, longer ones are split
+const graphMaxWindow = 5 * 365 * 24 * time.Hour
+
+// ListEvents lists the events of the calendar ending after from and starting before to, following every page.
+// Graph needs an upper bound, a zero to lists one year of events. Long ranges are listed in several calendar views.
+func (o *OutlookBackend) ListEvents(ctx context.Context, calendarID string, from time.Time, to time.Time) ([]*Event, error) {
+	calendarPath, err := o.calendarPath(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if to.IsZero() {
+		to = from.AddDate(1, 0, 0)
+	}
+	events := []*Event{}
+	seen := map[string]bool{} // events overlapping two windows are listed by both
+	for start := from; start.Before(to); start = start.Add(graphMaxWindow) {
+		end := start.Add(graphMaxWindow)
+		if end.After(to) {
+			end = to
+		}
+		window, err := o.calendarView(ctx, calendarPath, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range window {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				events = append(events, ev)
+			}
+		}
+	}
+	return events, nil
+}
+
+// calendarView lists the events of the calendar ending after from and starting before to, following every page
+func (o *OutlookBackend) calendarView(ctx context.Context, calendarPath string, from time.Time, to time.Time) ([]*Event, error) {
+	query := url.Values{
+		"startDateTime": {from.UTC().Format(time.RFC3339)},
+		"endDateTime":   {to.UTC().Format(time.RFC3339)},
+		"$expand":       {fmt.Sprintf("singleValueExtendedProperties($filter=id eq '%s')", outlookPropertyID)},
+		"$orderby":      {"start/dateTime"},
+		"$top":          {"100"},
+	}
+	target := calendarPath + "/calendarView?" + query.Encode()
+	events := []*Event{}
+	for target != "" {
+		page := &graphEvents{}
+		if err := o.do(ctx, "GET", target, nil, page); err != nil {
+			return nil, err
+		}
+		for index := range page.Value {
+			events = append(events, fromGraphEvent(&page.Value[index]))
+		}
+		target = page.NextLink
+	}
+	return events, nil
+}
+
+// Insert creates the event in the outlook calendar
+func (o *OutlookBackend) Insert(ctx context.Context, calendarID string, ev *Event) error {
+	calendarPath, err := o.calendarPath(ctx, calendarID)
+	if err != nil {
+		return err
+	}
+	created := &graphEvent{}
+	if err := o.do(ctx, "POST", calendarPath+"/events", o.toGraphEvent(ev), created); err != nil {
+		return err
+	}
+	ev.ID = created.ID
+	return nil
+}
+
+// Update replaces the fields of the outlook event
+func (o *OutlookBackend) Update(ctx context.Context, calendarID string, ev *Event) error {
+	return o.do(ctx, "PATCH", "/me/events/"+url.PathEscape(ev.ID), o.toGraphEvent(ev), nil)
+}
+
+// Delete removes the event from the outlook calendar
+func (o *OutlookBackend) Delete(ctx context.Context, calendarID string, ev *Event) error {
+	return o.do(ctx, "DELETE", "/me/events/"+url.PathEscape(ev.ID), nil, nil)
+}
+
+// Owned tells if the event carries the linker extended property
+func (o *OutlookBackend) Owned(ev *Event) bool {
+	return ev.Properties[propertyLinker] == linkerMarker
+}
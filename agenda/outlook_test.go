@@ -0,0 +1,285 @@
+package agenda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/intra"
+	"golang.org/x/oauth2"
+)
+
+// graphStub is a local stand-in of the Graph API holding the events of the default calendar, and the names of
+// the calendars. It serves the calendar view and the calendars in pages of pageSize items.
+type graphStub struct {
+	mu            sync.Mutex
+	server        *httptest.Server
+	events        []graphEvent
+	calendars     []string                 // the names of the calendars, their id is cal-<index>
+	posted        []map[string]interface{} // the bodies of the POST requests
+	pageSize      int
+	pages         int // calendar view pages served
+	calendarPages int // calendar list pages served
+}
+
+func newGraphStub(t *testing.T) *graphStub {
+	stub := &graphStub{pageSize: 2}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/me/calendar/calendarView":
+			if r.URL.Query().Get("startDateTime") == "" || r.URL.Query().Get("endDateTime") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			stub.pages++
+			from, _ := time.Parse(time.RFC3339, r.URL.Query().Get("startDateTime"))
+			to, _ := time.Parse(time.RFC3339, r.URL.Query().Get("endDateTime"))
+			view := []graphEvent{}
+			for _, gEv := range stub.events {
+				start, _ := time.Parse(graphDateTime, strings.Split(gEv.Start.DateTime, ".")[0])
+				end, _ := time.Parse(graphDateTime, strings.Split(gEv.End.DateTime, ".")[0])
+				if end.After(from) && start.Before(to) {
+					view = append(view, gEv)
+				}
+			}
+			skip := 0
+			fmt.Sscan(r.URL.Query().Get("$skip"), &skip)
+			page := graphEvents{Value: []graphEvent{}}
+			for i := skip; i < len(view) && i < skip+stub.pageSize; i++ {
+				page.Value = append(page.Value, view[i])
+			}
+			if skip+stub.pageSize < len(view) {
+				query := r.URL.Query()
+				query.Set("$skip", fmt.Sprint(skip+stub.pageSize))
+				page.NextLink = stub.server.URL + r.URL.Path + "?" + query.Encode()
+			}
+			json.NewEncoder(w).Encode(page)
+		case r.Method == "GET" && r.URL.Path == "/me/calendars":
+			stub.calendarPages++
+			skip := 0
+			fmt.Sscan(r.URL.Query().Get("$skip"), &skip)
+			page := graphCalendars{}
+			for i := skip; i < len(stub.calendars) && i < skip+stub.pageSize; i++ {
+				page.Value = append(page.Value, graphCalendar{ID: fmt.Sprintf("cal-%d", i), Name: stub.calendars[i]})
+			}
+			if skip+stub.pageSize < len(stub.calendars) {
+				query := r.URL.Query()
+				query.Set("$skip", fmt.Sprint(skip+stub.pageSize))
+				page.NextLink = stub.server.URL + r.URL.Path + "?" + query.Encode()
+			}
+			json.NewEncoder(w).Encode(page)
+		case r.Method == "POST" && r.URL.Path == "/me/calendar/events":
+			data, _ := ioutil.ReadAll(r.Body)
+			raw := map[string]interface{}{}
+			gEv := graphEvent{}
+			json.Unmarshal(data, &raw)
+			json.Unmarshal(data, &gEv)
+			stub.posted = append(stub.posted, raw)
+			gEv.ID = fmt.Sprintf("graph-%d", len(stub.events)+1)
+			// graph answers the dates in UTC, as asked by the Prefer header
+			for _, date := range []*graphDate{&gEv.Start, &gEv.End} {
+				loc, _ := time.LoadLocation(date.TimeZone)
+				parsed, _ := time.ParseInLocation(graphDateTime, date.DateTime, loc)
+				*date = graphDate{DateTime: parsed.UTC().Format(graphDateTime) + ".0000000", TimeZone: "UTC"}
+			}
+			gEv.Body.Content = strings.Replace(gEv.Body.Content, "\n", "\r\n", -1)
+			stub.events = append(stub.events, gEv)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(gEv)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return stub
+}
+
+func TestOutlookBackendRoundTrip(t *testing.T) {
+	stub := newGraphStub(t)
+	defer stub.server.Close()
+	ctx := context.Background()
+	backend := NewOutlookBackend(stub.server.Client(), stub.server.URL, "Europe/Paris")
+	config := testConfig()
+	config.ProjectParticipant = true
+
+	wanted := []*Event{}
+	for i := 0; i < 5; i++ {
+		project := project(t, "B-CPE-100", fmt.Sprintf("acti-%d", i))
+		project.Description = "Write a shell"
+		project.Participants = []string{"alice@epitech.eu", "bob@epitech.eu"}
+		project.ParticipantsName = []string{"Alice", "Bob"}
+		ev := projectEvent(t, config, project)
+		wanted = append(wanted, ev)
+		if err := backend.Insert(ctx, "primary", copyEvent(ev)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, posted := range stub.posted {
+		if _, ok := posted["attendees"]; ok {
+			t.Fatalf("the participants were invited: %v", posted["attendees"])
+		}
+	}
+
+	events, err := backend.ListEvents(ctx, "primary", time.Now().AddDate(0, 0, -7), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.pages != 3 || len(events) != 5 {
+		t.Fatalf("got %d events in %d pages, want 5 in 3", len(events), stub.pages)
+	}
+	for i, ev := range events {
+		if !backend.Owned(ev) || eventIdentity(ev.Properties) != eventIdentity(wanted[i].Properties) {
+			t.Errorf("event %d lost its properties: %v", i, ev.Properties)
+		}
+		if eventChanged(ev, wanted[i]) {
+			t.Errorf("event %d changed on the round trip: %+v, want %+v", i, ev, wanted[i])
+		}
+		if len(ev.Attendees) != 2 || ev.Attendees[1] != (Attendee{Email: "bob@epitech.eu", Name: "Bob"}) {
+			t.Errorf("event %d lost its participants: %+v", i, ev.Attendees)
+		}
+	}
+}
+
+func TestPurgeListsEveryOutlookEvent(t *testing.T) {
+	stub := newGraphStub(t)
+	defer stub.server.Close()
+	ctx := context.Background()
+	backend := NewOutlookBackend(stub.server.Client(), stub.server.URL, "Europe/Paris")
+	config := testConfig()
+	config.GoogleCalendarEvents, config.GoogleCalendarProjects = "primary", "primary"
+
+	for _, start := range []time.Time{time.Now().AddDate(-3, 0, 0), time.Now().AddDate(0, 1, 0)} {
+		ev := &Event{Summary: "Bootstrap", Start: start, End: start.Add(time.Hour), Properties: classProperties(intra.Event{CodeActi: "acti-1"})}
+		if err := backend.Insert(ctx, "primary", ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes, err := PlanPurge(ctx, backend, config, PurgeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d events to purge, want 2", len(changes))
+	}
+}
+
+func TestOutlookCalendarNames(t *testing.T) {
+	stub := newGraphStub(t)
+	defer stub.server.Close()
+	stub.calendars = []string{"Calendar", "Birthdays", "Holidays", "Work", "Epitech"}
+	backend := NewOutlookBackend(stub.server.Client(), stub.server.URL, "Europe/Paris")
+
+	tests := []struct {
+		calendarID string
+		path       string
+		pages      int // calendar list pages served
+	}{
+		{"primary", "/me/calendar", 0},
+		{"Epitech", "/me/calendars/cal-4", 3}, // on the last page
+		{"Birthdays", "/me/calendars/cal-1", 1},
+		{"AAMkAGI2", "/me/calendars/AAMkAGI2", 3}, // not a name, used as the id
+		{"Epitech", "/me/calendars/cal-4", 0},     // resolved once
+	}
+	for _, test := range tests {
+		stub.calendarPages = 0
+		path, err := backend.calendarPath(context.Background(), test.calendarID)
+		if err != nil || path != test.path || stub.calendarPages != test.pages {
+			t.Errorf("%s: got %s, %v in %d pages, want %s in %d", test.calendarID, path, err, stub.calendarPages, test.path, test.pages)
+		}
+	}
+}
+
+func TestSplitParticipants(t *testing.T) {
+	tests := []struct {
+		body        string
+		description string
+		attendees   int
+	}{
+		{"Write a shell", "Write a shell", 0},
+		{"Write a shell\r\n\r\nParticipants:\r\n- Alice <alice@epitech.eu>", "Write a shell", 1},
+		{"Participants:\n- Alice <alice@epitech.eu>\n- Bob <bob@epitech.eu>", "", 2},
+		{"Notes\n\nParticipants:\nnot a list", "Notes\n\nParticipants:\nnot a list", 0},
+	}
+	for _, test := range tests {
+		description, attendees := splitParticipants(test.body)
+		if description != test.description || len(attendees) != test.attendees {
+			t.Errorf("splitParticipants(%q) = %q, %v", test.body, description, attendees)
+		}
+	}
+}
+
+func TestDeviceCodePolling(t *testing.T) {
+	defer func(interval, slowDown time.Duration) {
+		devicePollInterval, deviceSlowDown = interval, slowDown
+	}(devicePollInterval, deviceSlowDown)
+	devicePollInterval, deviceSlowDown = time.Millisecond, time.Millisecond
+
+	answers := []string{
+		`{"error":"authorization_pending"}`,
+		`{"error":"slow_down"}`,
+		`{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`,
+	}
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/common/oauth2/v2.0/devicecode":
+			if r.Form.Get("client_id") != "client" || r.Form.Get("scope") != outlookScopes {
+				t.Errorf("unexpected device code request %v", r.Form)
+			}
+			fmt.Fprint(w, `{"device_code":"device","user_code":"ABCD","verification_uri":"https://microsoft.com/devicelogin","expires_in":60}`)
+		case "/common/oauth2/v2.0/token":
+			if r.Form.Get("device_code") != "device" || r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			if polls < len(answers) {
+				if polls < 2 {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				fmt.Fprint(w, answers[polls])
+			}
+			polls++
+		}
+	}))
+	defer server.Close()
+
+	config := outlookOAuthConfig("client", "", server.URL)
+	tok, err := getTokenFromDevice(context.Background(), config, outlookDeviceURL(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 || tok.AccessToken != "access" || tok.RefreshToken != "refresh" || !tok.Valid() {
+		t.Fatalf("got %+v after %d polls", tok, polls)
+	}
+}
+
+func TestDeviceCodeDenied(t *testing.T) {
+	defer func(interval time.Duration) { devicePollInterval = interval }(devicePollInterval)
+	devicePollInterval = time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/devicecode") {
+			fmt.Fprint(w, `{"device_code":"device","user_code":"ABCD","verification_uri":"https://microsoft.com/devicelogin","expires_in":60}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"access_denied","error_description":"the user declined"}`)
+	}))
+	defer server.Close()
+
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"}}
+	if _, err := getTokenFromDevice(context.Background(), config, server.URL+"/devicecode"); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("got %v, want access_denied", err)
+	}
+}
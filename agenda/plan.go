@@ -0,0 +1,216 @@
+package agenda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Actions of a change
+const (
+	ActionInsert = "insert"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Verbose logs every change applied to the calendars
+var Verbose = false
+
+// Change is an operation the synchronisation does on a calendar.
+// The exported fields describe it for the dry-run output.
+type Change struct {
+	Action   string    `json:"action"`
+	Kind     string    `json:"kind"` // class or project
+	Calendar string    `json:"calendar"`
+	Title    string    `json:"title"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Room     string    `json:"room,omitempty"`
+	Reason   string    `json:"reason"`
+	event    *Event    // the event to insert, the wanted event with the ID of the one to update, or the event to delete
+}
+
+func newChange(action string, kind string, calendarID string, ev *Event, reason string) Change {
+	return Change{
+		Action:   action,
+		Kind:     kind,
+		Calendar: calendarID,
+		Title:    ev.Summary,
+		Start:    ev.Start,
+		End:      ev.End,
+		Room:     ev.Location,
+		Reason:   reason,
+		event:    ev,
+	}
+}
+
+func insertChange(kind string, calendarID string, ev *Event, reason string) Change {
+	return newChange(ActionInsert, kind, calendarID, ev, reason)
+}
+
+// updateChange replaces the current calendar event by the wanted one
+func updateChange(kind string, calendarID string, current *Event, wanted *Event, reason string) Change {
+	wanted.ID = current.ID
+	return newChange(ActionUpdate, kind, calendarID, wanted, reason)
+}
+
+func deleteChange(kind string, calendarID string, ev *Event, reason string) Change {
+	return newChange(ActionDelete, kind, calendarID, ev, reason)
+}
+
+// changeReason describes what differs between the calendar event and the wanted one
+func changeReason(current *Event, wanted *Event) string {
+	reasons := []string{}
+
+	if !current.Start.Equal(wanted.Start) || !current.End.Equal(wanted.End) {
+		reasons = append(reasons, "time changed")
+	}
+	if current.Summary != wanted.Summary {
+		reasons = append(reasons, "title changed")
+	}
+	if current.Location != wanted.Location {
+		reasons = append(reasons, "room changed")
+	}
+	if normalizeNewlines(current.Description) != normalizeNewlines(wanted.Description) {
+		reasons = append(reasons, "description changed")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// syncReport counts the operations done on a calendar during a synchronisation
+type syncReport struct {
+	inserted int
+	updated  int
+	deleted  int
+}
+
+// ChangeError is the failure of a change of a calendar
+type ChangeError struct {
+	Change Change
+	Err    error
+}
+
+func (e *ChangeError) Error() string {
+	return fmt.Sprintf("unable to %s event %q: %v", e.Change.Action, e.Change.Title, e.Err)
+}
+
+// Unwrap returns the error of the backend, a CalendarError telling the reason of the failure
+func (e *ChangeError) Unwrap() error {
+	return e.Err
+}
+
+// ChangeErrors gathers the failures of ApplyChanges. The other changes were still performed.
+type ChangeErrors []*ChangeError
+
+func (e ChangeErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d calendar changes failed, the first one: %v", len(e), e[0])
+}
+
+// Is tells if any of the changes failed with target, such as ErrUnauthorized
+func (e ChangeErrors) Is(target error) bool {
+	for _, changeErr := range e {
+		if errors.Is(changeErr, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyChanges performs the changes on the calendars and counts the successful ones by kind.
+// Backends supporting it receive every change in batches. Failed changes are logged, skipped and returned.
+// Once ctx is done, the change in progress is completed and the remaining ones are skipped.
+func applyChanges(ctx context.Context, backend CalendarBackend, changes []Change, reports map[string]*syncReport) ChangeErrors {
+	errs := make([]error, len(changes))
+	if batcher, ok := backend.(BatchBackend); ok {
+		ops := make([]Operation, len(changes))
+		for i, change := range changes {
+			ops[i] = Operation{Action: change.Action, Calendar: change.Calendar, Event: change.event}
+		}
+		errs = batcher.Batch(ctx, ops)
+	} else {
+		for i, change := range changes {
+			if errs[i] = ctx.Err(); errs[i] == nil {
+				errs[i] = applyChange(detachedContext{ctx}, backend, change)
+			}
+		}
+	}
+
+	skipped := 0
+	failures := ChangeErrors{}
+	for i, change := range changes {
+		if errs[i] != nil && errors.Is(errs[i], ctx.Err()) {
+			skipped++
+			continue
+		}
+		if errs[i] != nil {
+			Logger(ctx).Printf("Unable to %s event %q. %v\n", change.Action, change.Title, errs[i])
+			failures = append(failures, &ChangeError{Change: change, Err: errs[i]})
+			continue
+		}
+		report := reports[change.Kind]
+		switch change.Action {
+		case ActionInsert:
+			report.inserted++
+		case ActionUpdate:
+			report.updated++
+		case ActionDelete:
+			report.deleted++
+		}
+		if Verbose {
+			Logger(ctx).Printf("%s %s %q (%s): %s\n", change.Action, change.Kind, change.Title,
+				change.Start.Format("2006-01-02 15:04"), change.Reason)
+		}
+	}
+	if skipped != 0 {
+		Logger(ctx).Printf("Interrupted, %d changes skipped: %v\n", skipped, ctx.Err())
+	}
+	return failures
+}
+
+// applyChange performs a single change on the calendar
+func applyChange(ctx context.Context, backend CalendarBackend, change Change) error {
+	switch change.Action {
+	case ActionInsert:
+		return backend.Insert(ctx, change.Calendar, change.event)
+	case ActionUpdate:
+		return backend.Update(ctx, change.Calendar, change.event)
+	case ActionDelete:
+		return backend.Delete(ctx, change.Calendar, change.event)
+	}
+	return fmt.Errorf("unknown action %q", change.Action)
+}
+
+func newReports() map[string]*syncReport {
+	return map[string]*syncReport{kindClass: {}, kindProject: {}}
+}
+
+func logReports(ctx context.Context, reports map[string]*syncReport) {
+	Logger(ctx).Printf("Events: %d inserted, %d updated, %d deleted\n",
+		reports[kindClass].inserted, reports[kindClass].updated, reports[kindClass].deleted)
+	Logger(ctx).Printf("Projects: %d inserted, %d updated, %d deleted\n",
+		reports[kindProject].inserted, reports[kindProject].updated, reports[kindProject].deleted)
+}
+
+// ApplyChanges performs the changes on the calendars. Failed changes are logged and skipped, then returned
+// as ChangeErrors. Once ctx is done, the changes already started are completed, the remaining ones are skipped
+// and the error of ctx is returned.
+func ApplyChanges(ctx context.Context, backend CalendarBackend, changes []Change) error {
+	reports := newReports()
+
+	failures := applyChanges(ctx, backend, changes, reports)
+	logReports(ctx, reports)
+	return changesError(ctx, failures)
+}
+
+// changesError returns the failures of the changes if any, else the error of ctx when it interrupted them
+func changesError(ctx context.Context, failures ChangeErrors) error {
+	if len(failures) != 0 {
+		return failures
+	}
+	return ctx.Err()
+}
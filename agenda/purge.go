@@ -0,0 +1,91 @@
+package agenda
+
+import (
+	"context"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// purgeBatchSize is the number of events deleted between two progress reports of a purge
+const purgeBatchSize = 50
+
+// purgeFutureYears bounds the purge without a To date, as some backends need an upper bound to list events
+const purgeFutureYears = 10
+
+// PurgeFilter restricts the events deleted by a purge. Zero fields match every event.
+type PurgeFilter struct {
+	From   time.Time // events ending after From
+	To     time.Time // events starting before To
+	Module string    // module code, eg: B-PRO-500
+	Kind   string    // "class" or "project"
+}
+
+// match tells if the event created by the linker matches the filter.
+// Events created by older versions of the linker have no module and never match a module filter.
+func (f PurgeFilter) match(ev *Event, kind string) bool {
+	if f.Kind != "" && f.Kind != kind {
+		return false
+	}
+	return f.Module == "" || ev.Properties[propertyModule] == f.Module
+}
+
+// PlanPurge lists the events created by the linker in the events and projects calendars of the config
+// and returns the changes deleting those matching the filter. Every event is listed, not only the upcoming ones.
+func PlanPurge(ctx context.Context, backend CalendarBackend, config *parser.Config, filter PurgeFilter) ([]Change, error) {
+	from := filter.From
+	if from.IsZero() {
+		from = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	to := filter.To
+	if to.IsZero() {
+		to = time.Now().AddDate(purgeFutureYears, 0, 0)
+	}
+	calendars := []string{config.GoogleCalendarEvents}
+	if config.GoogleCalendarProjects != config.GoogleCalendarEvents {
+		calendars = append(calendars, config.GoogleCalendarProjects)
+	}
+	changes := []Change{}
+
+	for _, calendarID := range calendars {
+		calEvents, err := backend.ListEvents(ctx, calendarID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, cEv := range calEvents {
+			kind := cEv.Properties[propertyKind]
+			if !backend.Owned(cEv) {
+				if !linkerDescription.MatchString(cEv.Description) { // not created by an older version either
+					continue
+				}
+				kind = kindClass
+			}
+			if kind != kindClass && kind != kindProject {
+				continue
+			}
+			if filter.match(cEv, kind) {
+				changes = append(changes, deleteChange(kind, calendarID, cEv, "purge"))
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Purge performs the changes of PlanPurge by batches, logging the progress after each of them.
+// The deletions that failed are returned as ChangeErrors, see ApplyChanges.
+// Once ctx is done, the batch in progress is completed and the purge stops.
+func Purge(ctx context.Context, backend CalendarBackend, changes []Change) error {
+	reports := newReports()
+	failures := ChangeErrors{}
+
+	for start := 0; start < len(changes) && ctx.Err() == nil; start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		failures = append(failures, applyChanges(ctx, backend, changes[start:end], reports)...)
+		Logger(ctx).Printf("Purge: %d/%d events processed\n", end, len(changes))
+	}
+	logReports(ctx, reports)
+	return changesError(ctx, failures)
+}
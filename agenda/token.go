@@ -0,0 +1,97 @@
+package agenda
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nheuillet/calendar-linker/parser"
+	"github.com/nheuillet/calendar-linker/secrets"
+	"golang.org/x/oauth2"
+)
+
+// tokenStorage is where an oauth token is saved: the secret store of the config when it has one, the token file otherwise
+type tokenStorage struct {
+	store secrets.Store
+	key   string
+	file  string
+}
+
+// newTokenStorage returns the storage of the token of file. In the secret store, the token is saved under the name of the file.
+func newTokenStorage(config *parser.Config, file string) (*tokenStorage, error) {
+	store, err := config.OpenSecretStore()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the secret store: %v", err)
+	}
+	return &tokenStorage{store: store, key: config.SecretKey(filepath.Base(file)), file: file}, nil
+}
+
+// load reads the token
+func (s *tokenStorage) load() (*oauth2.Token, error) {
+	if s.store == nil {
+		return tokenFromFile(s.file)
+	}
+	value, err := s.store.Get(s.key)
+	if err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{}
+	err = json.Unmarshal([]byte(value), tok)
+	return tok, err
+}
+
+// save replaces the token, telling the user where it is saved
+func (s *tokenStorage) save(tok *oauth2.Token) error {
+	if s.store == nil {
+		return saveToken(s.file, tok)
+	}
+	fmt.Printf("Saving credential to %s\n", s.store)
+	return s.write(tok)
+}
+
+// write replaces the token silently, as done on every refresh
+func (s *tokenStorage) write(tok *oauth2.Token) error {
+	if s.store == nil {
+		return writeToken(s.file, tok)
+	}
+	value, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Set(s.key, string(value)); err != nil {
+		return fmt.Errorf("unable to store oauth token: %v", err)
+	}
+	return nil
+}
+
+// MigrateTokens moves the google and outlook tokens of the config from their files to the secret store,
+// deleting the files. It returns the files moved.
+func MigrateTokens(config *parser.Config) ([]string, error) {
+	moved := []string{}
+	for _, file := range []string{config.TokenFile, outlookTokenFile(config.TokenFile)} {
+		tok, err := tokenFromFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("unable to read %s: %v", file, err)
+		}
+		storage, err := newTokenStorage(config, file)
+		if err != nil {
+			return moved, err
+		}
+		if storage.store == nil {
+			return moved, errors.New("no secret store, set secret_store in the config file")
+		}
+		if err := storage.save(tok); err != nil {
+			return moved, err
+		}
+		if err := os.Remove(file); err != nil {
+			return moved, err
+		}
+		moved = append(moved, file)
+	}
+	return moved, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/agenda"
+	"github.com/nheuillet/calendar-linker/intra"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// command is a subcommand of the command line. run receives the arguments following the command name
+// and a context cancelled when the program is interrupted.
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"sync", "fetch the planning from the intra and synchronise the calendars", runSync},
+	{"auth", "log in to the calendar and save the token, replacing the previous one", runAuth},
+	{"list", "print the upcoming registered events", runList},
+	{"projects", "print the projects of the configured semesters", runProjects},
+	{"export", "export the planning as iCalendar files", runExport},
+	{"serve", "serve the planning as live iCalendar feeds", runServe},
+	{"daemon", "synchronise the calendars on an interval", runDaemon},
+	{"purge", "delete every event created by the linker", runPurge},
+	{"validate-config", "check the config file", runValidateConfig},
+	{"migrate-secrets", "move the secrets of the config file and the oauth tokens to the secret store", runMigrateSecrets},
+	{"version", "print the version", runVersion},
+}
+
+// newFlagSet creates the flag set of a command
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(os.Args[0]+" "+name, flag.ExitOnError)
+}
+
+// printPlan prints the changes of a dry-run, as a table or as JSON
+func printPlan(changes []agenda.Change, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(changes)
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to do, the calendars are up to date.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tKIND\tTITLE\tSTART\tEND\tROOM\tCALENDAR\tREASON")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", change.Action, change.Kind, change.Title,
+			change.Start.Format("2006-01-02 15:04"), change.End.Format("2006-01-02 15:04"),
+			change.Room, change.Calendar, change.Reason)
+	}
+	return w.Flush()
+}
+
+func runSync(ctx context.Context, args []string) error {
+	flags := newFlagSet("sync")
+	dryRun := flags.Bool("dry-run", false, "print the changes the synchronisation would do without modifying the calendar")
+	jsonOutput := flags.Bool("json", false, "print the dry-run changes as JSON")
+	flags.Parse(args)
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	return syncProfiles(ctx, profiles, *dryRun, *jsonOutput)
+}
+
+func runAuth(ctx context.Context, args []string) error {
+	newFlagSet("auth").Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return agenda.Authenticate(ctx, config)
+}
+
+func runList(ctx context.Context, args []string) error {
+	newFlagSet("list").Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	client, err := intra.NewClientFromConfig(config, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	events, err := fetchEvents(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tEND\tMODULE\tTITLE\tROOM")
+	for _, ev := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ev.Start, ev.End, ev.ModuleTitle, ev.ActiTitle, ev.Room.Code)
+	}
+	return w.Flush()
+}
+
+func runProjects(ctx context.Context, args []string) error {
+	newFlagSet("projects").Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	client, err := intra.NewClientFromConfig(config, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	projects, err := fetchProjects(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BEGIN\tEND\tMODULE\tTITLE\tGROUP")
+	for _, project := range *projects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", project.Begin, project.End, project.CodeModule, project.Title,
+			strings.Join(project.ParticipantsName, ", "))
+	}
+	return w.Flush()
+}
+
+// writeICSFile exports the events and projects to the iCalendar file at path
+func writeICSFile(path string, config *parser.Config, name string, events []intra.Event, projects []intra.Activity) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = agenda.WriteICS(f, config, name, events, projects)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runExport writes the events and projects to the files given on the command line.
+// Giving the same file for both exports a single calendar.
+func runExport(ctx context.Context, args []string) error {
+	flags := newFlagSet("export")
+	eventsFile := flags.String("events", "events.ics", "file the events are exported to")
+	projectsFile := flags.String("projects", "projects.ics", "file the projects are exported to")
+	flags.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	registeredEvents, projects, fetchErrs, err := fetchPlanning(ctx, config)
+	if err != nil {
+		return err
+	}
+	logRunReport(ctx, registeredEvents, projects, fetchErrs)
+	var projectList []intra.Activity
+	if projects != nil {
+		projectList = *projects
+	}
+	if *eventsFile == *projectsFile {
+		return writeICSFile(*eventsFile, config, "Epitech", *registeredEvents, projectList)
+	}
+	if err := writeICSFile(*eventsFile, config, "Epitech events", *registeredEvents, nil); err != nil {
+		return err
+	}
+	if projects == nil {
+		return nil
+	}
+	return writeICSFile(*projectsFile, config, "Epitech projects", nil, projectList)
+}
+
+func runServe(ctx context.Context, args []string) error {
+	newFlagSet("serve").Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return serve(ctx, config)
+}
+
+func runDaemon(ctx context.Context, args []string) error {
+	newFlagSet("daemon").Parse(args)
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	daemon(ctx, profiles)
+	return nil
+}
+
+// confirm asks the user a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// parseDate parses a YYYY-MM-DD date of the command line in the local timezone. An empty date is the zero time.
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", date, time.Local)
+}
+
+func runPurge(ctx context.Context, args []string) error {
+	flags := newFlagSet("purge")
+	from := flags.String("from", "", "only delete the events ending after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "only delete the events starting before this date (YYYY-MM-DD)")
+	module := flags.String("module", "", "only delete the events of this module code")
+	kind := flags.String("kind", "", "only delete the events of this kind: class or project")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	flags.Parse(args)
+
+	filter := agenda.PurgeFilter{Module: *module, Kind: *kind}
+	var err error
+	if filter.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("invalid -from date: %v", err)
+	}
+	if filter.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("invalid -to date: %v", err)
+	}
+	if filter.Kind != "" && filter.Kind != "class" && filter.Kind != "project" {
+		return fmt.Errorf("invalid -kind %q, expected class or project", filter.Kind)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	backend, err := agenda.NewBackend(ctx, config)
+	if err != nil {
+		return err
+	}
+	// -timeout bounds the listing and the deletions, not the time the user takes to log in or to confirm
+	listCtx, cancelList := runContext(ctx)
+	changes, err := agenda.PlanPurge(listCtx, backend, config, filter)
+	cancelList()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("No event created by the linker found.")
+		return nil
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete %d events created by the linker?", len(changes))) {
+		return nil
+	}
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	return agenda.Purge(ctx, backend, changes)
+}
+
+func runValidateConfig(ctx context.Context, args []string) error {
+	newFlagSet("validate-config").Parse(args)
+
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+	problems := parser.ValidationError{}
+	if err := config.Validate(); err != nil {
+		problems = err.(parser.ValidationError)
+	}
+	if len(problems) != 0 {
+		return invalidConfig(problems)
+	}
+	if len(config.Profiles()) > 1 {
+		fmt.Printf("%s is valid, with %d profiles\n", *configPath, len(config.Profiles()))
+		return nil
+	}
+	fmt.Printf("%s is valid\n", *configPath)
+	return nil
+}
+
+func runVersion(ctx context.Context, args []string) error {
+	newFlagSet("version").Parse(args)
+
+	fmt.Println("calendar-linker " + version)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/agenda"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+const (
+	defaultSyncInterval = 60 // minutes
+	maxBackoffFactor    = 8  // the delay after consecutive failures never exceeds 8 times the interval
+)
+
+// nextRun returns the delay before the next synchronisation: the interval give or take 10%,
+// doubled for every consecutive failure so that an unavailable intra is not hammered.
+func nextRun(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < interval*maxBackoffFactor; i++ {
+		delay *= 2
+	}
+	if delay > interval*maxBackoffFactor {
+		delay = interval * maxBackoffFactor
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5+1)) - delay/10
+	return delay + jitter
+}
+
+// syncInterval returns the interval between two synchronisations from the config
+func syncInterval(config *parser.Config) time.Duration {
+	if config.SyncInterval <= 0 {
+		return defaultSyncInterval * time.Minute
+	}
+	return time.Duration(config.SyncInterval) * time.Minute
+}
+
+// daemonProfile synchronises the calendar of the profile every sync_interval minutes until ctx is done or stop is closed.
+// A synchronisation in progress is completed when stop is closed, and abandons its fetches when ctx is done.
+func daemonProfile(ctx context.Context, stop <-chan struct{}, run *profileRun) {
+	ctx = profileContext(ctx, run.config)
+	logger := agenda.Logger(ctx)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	failures := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-timer.C:
+			if err := synchronise(ctx, run.config, run.backend); err != nil {
+				failures++
+				logger.Printf("Synchronisation failed (%d in a row): %v\n", failures, err)
+			} else {
+				failures = 0
+			}
+			delay := nextRun(syncInterval(run.config), failures)
+			logger.Printf("Next synchronisation in %v\n", delay.Round(time.Second))
+			timer.Reset(delay)
+		}
+	}
+}
+
+// startProfiles runs daemonProfile for the profiles whose backend could be created, until stop is closed
+func startProfiles(ctx context.Context, stop <-chan struct{}, runs []*profileRun, wg *sync.WaitGroup) {
+	for _, run := range runs {
+		if run.err != nil {
+			log.Printf("Unable to create the calendar backend of %s, skipping it: %v\n", profileLabel(run.config), run.err)
+			continue
+		}
+		wg.Add(1)
+		go func(run *profileRun) {
+			defer wg.Done()
+			daemonProfile(ctx, stop, run)
+		}(run)
+	}
+}
+
+// profileLabel names the profile in the logs
+func profileLabel(config *parser.Config) string {
+	if config.Name == "" {
+		return "the config"
+	}
+	return "profile " + config.Name
+}
+
+// daemon synchronises the calendars of the profiles, each every sync_interval minutes of its own and independently
+// of the other ones, until ctx is done, on SIGTERM or SIGINT. A synchronisation in progress then abandons its fetches
+// and completes the calendar changes already started.
+// SIGHUP reloads the config file once the synchronisations in progress are done, then synchronises again.
+func daemon(ctx context.Context, profiles []*parser.Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	rand.Seed(time.Now().UnixNano())
+
+	wg := &sync.WaitGroup{}
+	stop := make(chan struct{})
+	runs := newProfileRuns(ctx, profiles)
+	startProfiles(ctx, stop, runs, wg)
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			log.Println("Exiting")
+			return
+		case <-signals:
+			newProfiles, err := loadProfiles()
+			if err != nil {
+				log.Printf("Unable to reload the config file, keeping the previous one: %v\n", err)
+				continue
+			}
+			newRuns := newProfileRuns(ctx, newProfiles)
+			for i, newRun := range newRuns {
+				for _, run := range runs {
+					if newRun.err != nil && run.err == nil && run.config.Name == newRun.config.Name {
+						log.Printf("Unable to reload the calendar backend of %s, keeping its previous config: %v\n",
+							profileLabel(run.config), newRun.err)
+						newRuns[i] = run
+					}
+				}
+			}
+			close(stop)
+			wg.Wait()
+			stop = make(chan struct{})
+			runs = newRuns
+			startProfiles(ctx, stop, runs, wg)
+			log.Println("Config file reloaded")
+		}
+	}
+}
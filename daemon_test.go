@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+func TestNextRun(t *testing.T) {
+	interval := time.Hour
+	tests := []struct {
+		failures int
+		delay    time.Duration // before the jitter
+	}{
+		{0, time.Hour},
+		{1, 2 * time.Hour},
+		{2, 4 * time.Hour},
+		{3, 8 * time.Hour},
+		{4, 8 * time.Hour}, // capped to 8 times the interval
+		{1000, 8 * time.Hour},
+	}
+	for _, test := range tests {
+		min, max := test.delay, time.Duration(0)
+		for i := 0; i < 1000; i++ {
+			delay := nextRun(interval, test.failures)
+			if delay < min {
+				min = delay
+			}
+			if delay > max {
+				max = delay
+			}
+		}
+		// give or take 10%, spread over the whole range
+		if min < test.delay*9/10 || max > test.delay*11/10 {
+			t.Errorf("after %d failures, the delay goes from %v to %v, want %v give or take 10%%", test.failures, min, max, test.delay)
+		}
+		if min > test.delay*19/20 || max < test.delay*21/20 {
+			t.Errorf("after %d failures, the delay only goes from %v to %v, want a jitter of 10%% of %v", test.failures, min, max, test.delay)
+		}
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	tests := []struct {
+		minutes int
+		want    time.Duration
+	}{
+		{0, time.Hour},
+		{-5, time.Hour},
+		{15, 15 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := syncInterval(&parser.Config{SyncInterval: test.minutes}); got != test.want {
+			t.Errorf("syncInterval(%d) = %v, want %v", test.minutes, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,11 @@ module github.com/nheuillet/calendar-linker
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/zalando/go-keyring v0.1.1
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/oauth2 v0.0.0-20201208152858-08078c50e5b5
+	golang.org/x/term v0.0.0-20201117132131-f5c789dd3221
 	google.golang.org/api v0.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,306 @@
+package intra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// DefaultBaseURL is the url of the Epitech intranet
+const DefaultBaseURL = "https://intra.epitech.eu"
+
+// DefaultTimeout is the timeout of the http client created when none is given to NewClient
+const DefaultTimeout = 10 * time.Second
+
+// Limits of the requests sent to the intra
+const (
+	maxConcurrentRequests = 4  // number of requests running at the same time
+	requestsPerSecond     = 5  // rate of the token bucket
+	requestsBurst         = 10 // size of the token bucket
+	maxAttempts           = 5  // number of times a request is sent before giving up
+)
+
+// Delays between the attempts of a request
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second // also caps the Retry-After of the intra
+)
+
+// StatusError is returned when the intra answers a request with a non 2xx status
+type StatusError struct {
+	URL        string // the url of the request, without the autologin
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("intra GET %s: %s", e.URL, e.Status)
+}
+
+// autologinPattern matches the autologin token of the intra urls, hidden from the errors and logs
+var autologinPattern = regexp.MustCompile(`auth-[0-9a-zA-Z]+`)
+
+func redactURL(rawURL string) string {
+	return autologinPattern.ReplaceAllString(rawURL, "auth-***")
+}
+
+// rateLimiter is a token bucket: a request takes a token, and the bucket refills at a constant rate up to its size.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, sleeping until one is available or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens-- // the token is reserved even if it is not available yet, so that waiting requests keep their order
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// sleep waits for the duration, returning early with the error of ctx when it is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Client sends the requests to the intra API. It bounds the number of concurrent requests, limits their rate
+// and retries the ones failing with a timeout, a 429 or a 5xx status, waiting longer after each attempt.
+// A Client can be used by several goroutines.
+type Client struct {
+	baseURL   string
+	autologin string
+	http      *http.Client
+	logger    *log.Logger
+	limiter   *rateLimiter
+	slots     chan struct{}
+}
+
+// NewClient creates a client of the intra at baseURL, DefaultBaseURL if empty, logged in with the autologin token.
+// The autologin may also be the whole autologin link. A nil httpClient or logger is replaced by a default one.
+func NewClient(baseURL string, autologin string, httpClient *http.Client, logger *log.Logger) (*Client, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid intra url: %v", err)
+	}
+	autologin = strings.Trim(autologin, "/")
+	autologin = autologin[strings.LastIndex(autologin, "/")+1:]
+	if autologin == "" {
+		return nil, fmt.Errorf("missing intra autologin")
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &Client{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		autologin: autologin,
+		http:      httpClient,
+		logger:    logger,
+		limiter:   newRateLimiter(requestsPerSecond, requestsBurst),
+		slots:     make(chan struct{}, maxConcurrentRequests),
+	}, nil
+}
+
+// route returns the url of the route of the API: the path segments are escaped and the json format is requested.
+// An empty last segment adds the trailing slash of the routes of the modules.
+func (c *Client) route(query url.Values, segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("format", "json")
+	return c.baseURL + "/" + c.autologin + "/" + strings.Join(escaped, "/") + "?" + query.Encode()
+}
+
+// Planning returns the events of the planning of the location between from and to, dates included.
+// The intra already filters the events of the promotion and modules of the user.
+func (c *Client) Planning(ctx context.Context, location string, from time.Time, to time.Time) ([]Event, error) {
+	query := url.Values{}
+	query.Set("location", location)
+	query.Set("onlymypromo", "true")
+	query.Set("onlymymodule", "true")
+	query.Set("start", from.Format(parser.DateFormat))
+	query.Set("end", to.Format(parser.DateFormat))
+	events := []Event{}
+	err := c.getJSON(ctx, c.route(query, "planning", "load"), &events)
+	return events, err
+}
+
+// Modules returns the modules of every semester of the user
+func (c *Client) Modules(ctx context.Context) ([]Module, error) {
+	modules := []Module{}
+	err := c.getJSON(ctx, c.route(nil, "course", "filter"), &modules)
+	return modules, err
+}
+
+// Module returns the activities of a module instance, with the codes of the module filled in
+func (c *Client) Module(ctx context.Context, year int, code string, instance string) ([]Activity, error) {
+	module := &Activities{}
+	if err := c.getJSON(ctx, c.route(nil, "module", strconv.Itoa(year), code, instance, ""), module); err != nil {
+		return nil, err
+	}
+	for index := range module.Activities {
+		module.Activities[index].CodeModule = code
+		module.Activities[index].CodeInstance = instance
+		module.Activities[index].ScolarYear = strconv.Itoa(year)
+	}
+	return module.Activities, nil
+}
+
+// ProjectGroup returns the project of an activity with its registered groups
+func (c *Client) ProjectGroup(ctx context.Context, year int, code string, instance string, acti string) (*Project, error) {
+	project := &Project{}
+	err := c.getJSON(ctx, c.route(nil, "module", strconv.Itoa(year), code, instance, acti, "project", ""), project)
+	return project, err
+}
+
+// retryDelay returns the time to wait before sending a request again: the Retry-After of the answer if any,
+// an exponential backoff with jitter otherwise.
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		retryAfter := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return minDuration(time.Duration(seconds)*time.Second, retryMaxDelay)
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return minDuration(time.Until(date), retryMaxDelay)
+		}
+	}
+	backoff := minDuration(retryBaseDelay<<uint(attempt), retryMaxDelay)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func minDuration(a time.Duration, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// isTimeout tells if the request failed because the intra took too long to answer
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// get executes a GET request and returns the body of the answer, retrying the temporary failures.
+func (c *Client) get(ctx context.Context, rawURL string) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		body, resp, err := c.try(ctx, rawURL)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if statusErr, ok := err.(*StatusError); ok {
+			if statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode < 500 {
+				return nil, err
+			}
+		} else if !isTimeout(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		if attempt != maxAttempts-1 {
+			delay := retryDelay(attempt, resp)
+			c.logger.Printf("%v, retrying in %v\n", err, delay.Round(time.Millisecond))
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// try sends a single request, once a concurrency slot and a token of the rate limiter are available
+func (c *Client) try(ctx context.Context, rawURL string) ([]byte, *http.Response, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	defer func() { <-c.slots }()
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		ioutil.ReadAll(resp.Body)
+		return nil, resp, &StatusError{URL: redactURL(rawURL), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp, err
+	}
+	return body, resp, nil
+}
+
+// getJSON executes a GET request then decodes the answer as JSON.
+func (c *Client) getJSON(ctx context.Context, rawURL string, target interface{}) error {
+	body, err := c.get(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("intra GET %s: invalid answer: %v", redactURL(rawURL), err)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package intra
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is a fake transport answering the requests with a function
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// timeoutError is the error of a request the intra took too long to answer
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout awaiting response headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// fakeAnswer is an answer of the fake transport: an error, or a status with its headers and body
+type fakeAnswer struct {
+	err    error
+	status int
+	header http.Header
+	body   string
+}
+
+// newFakeClient returns a client whose requests get the answers in order, the last one being repeated,
+// and a function returning the number of requests sent.
+func newFakeClient(t *testing.T, answers ...fakeAnswer) (*Client, func() int) {
+	mu := sync.Mutex{}
+	requests := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		answer := answers[len(answers)-1]
+		if requests < len(answers) {
+			answer = answers[requests]
+		}
+		requests++
+		if answer.err != nil {
+			return nil, answer.err
+		}
+		header := answer.header
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			StatusCode: answer.status,
+			Status:     http.StatusText(answer.status),
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(answer.body)),
+			Request:    req,
+		}, nil
+	})
+	client, err := NewClient("https://intra.epitech.eu", "auth-0123abcd", &http.Client{Transport: transport}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// shortDelays makes the retries of the test immediate, and returns the function restoring the delays
+func shortDelays() func() {
+	base, max := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = time.Millisecond, 10*time.Millisecond
+	return func() { retryBaseDelay, retryMaxDelay = base, max }
+}
+
+func TestGetRetriesTemporaryFailures(t *testing.T) {
+	defer shortDelays()()
+	client, requests := newFakeClient(t,
+		fakeAnswer{status: http.StatusServiceUnavailable},
+		fakeAnswer{status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"0"}}},
+		fakeAnswer{err: timeoutError{}},
+		fakeAnswer{status: http.StatusOK, body: `[]`},
+	)
+	body, err := client.get(context.Background(), "https://intra.epitech.eu/auth-0123abcd/course/filter?format=json")
+	if err != nil || string(body) != "[]" || requests() != 4 {
+		t.Fatalf("got %q and %v after %d requests, want [] after 4", body, err, requests())
+	}
+}
+
+func TestGetStopsOnPermanentFailures(t *testing.T) {
+	defer shortDelays()()
+	tests := []struct {
+		name     string
+		answer   fakeAnswer
+		requests int
+	}{
+		{"not found", fakeAnswer{status: http.StatusNotFound}, 1},
+		{"forbidden", fakeAnswer{status: http.StatusForbidden}, 1},
+		{"refused connection", fakeAnswer{err: errors.New("connection refused")}, 1},
+		{"server always failing", fakeAnswer{status: http.StatusBadGateway}, maxAttempts},
+		{"intra always too slow", fakeAnswer{err: timeoutError{}}, maxAttempts},
+	}
+	for _, test := range tests {
+		client, requests := newFakeClient(t, test.answer)
+		_, err := client.get(context.Background(), "https://intra.epitech.eu/auth-0123abcd/course/filter?format=json")
+		if err == nil || requests() != test.requests {
+			t.Errorf("%s: got %v after %d requests, want an error after %d", test.name, err, requests(), test.requests)
+		}
+		var statusErr *StatusError
+		if test.answer.status != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != test.answer.status) {
+			t.Errorf("%s: got %v, want a StatusError %d", test.name, err, test.answer.status)
+		}
+	}
+}
+
+func TestGetStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, requests := newFakeClient(t, fakeAnswer{status: http.StatusServiceUnavailable, header: http.Header{"Retry-After": {"20"}}})
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := client.get(ctx, "https://intra.epitech.eu/auth-0123abcd/course/filter?format=json"); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if requests() != 1 || time.Since(start) > time.Second {
+		t.Fatalf("%d requests in %v, the retry was not interrupted", requests(), time.Since(start))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	answer := func(retryAfter string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {retryAfter}}}
+	}
+	tests := []struct {
+		name     string
+		attempt  int
+		resp     *http.Response
+		min, max time.Duration
+	}{
+		{"retry after seconds", 0, answer("2"), 2 * time.Second, 2 * time.Second},
+		{"retry after too long", 0, answer("3600"), retryMaxDelay, retryMaxDelay},
+		{"retry after date", 0, answer(time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)), 8 * time.Second, 10 * time.Second},
+		{"invalid retry after", 0, answer("soon"), retryBaseDelay / 2, retryBaseDelay},
+		{"first backoff", 0, nil, retryBaseDelay / 2, retryBaseDelay},
+		{"third backoff", 2, nil, 2 * retryBaseDelay, 4 * retryBaseDelay},
+		{"capped backoff", 20, nil, retryMaxDelay / 2, retryMaxDelay},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ { // the backoff is random
+			if delay := retryDelay(test.attempt, test.resp); delay < test.min || delay > test.max {
+				t.Errorf("%s: got %v, want %v to %v", test.name, delay, test.min, test.max)
+				break
+			}
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(50, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := limiter.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Fatalf("the burst waited %v", elapsed)
+	}
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("3 requests after the burst took %v, want about 60ms at 50 per second", elapsed)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	limiter.wait(ctx)
+	if err := limiter.wait(canceled); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+// serveIntra serves the answers of a fake intra by path, recording the requests with their raw query.
+// The other paths, and the requests without format=json, are not found.
+func serveIntra(t *testing.T, answers map[string]string, requested *[]string) (*httptest.Server, *Client) {
+	mu := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*requested = append(*requested, r.URL.Path+"?"+r.URL.RawQuery)
+		mu.Unlock()
+		answer, ok := answers[r.URL.Path]
+		if !ok || r.URL.Query().Get("format") != "json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(answer))
+	}))
+	client, err := NewClient(server.URL+"/", server.URL+"/auth-0123abcd", server.Client(), log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// newFakeIntra serves the routes of the intra used by the client, for the autologin auth-0123abcd
+func newFakeIntra(t *testing.T, requested *[]string) (*httptest.Server, *Client) {
+	return serveIntra(t, map[string]string{
+		"/auth-0123abcd/planning/load":                                 `[{"codeacti":"acti-1","codeevent":"event-1"}]`,
+		"/auth-0123abcd/course/filter":                                 `[{"code":"B-CPE-100","codeinstance":"TLS-1-1","scolaryear":2026}]`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/":                `{"activites":[{"codeacti":"acti-1","is_projet":true}]}`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/acti-1/project/": `{"title":"Shell","registered":[]}`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/acti-2/project/": `{"title":`,
+	}, requested)
+}
+
+func TestClientRoutes(t *testing.T) {
+	requested := []string{}
+	server, client := newFakeIntra(t, &requested)
+	defer server.Close()
+	ctx := context.Background()
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if events, err := client.Planning(ctx, "FR/TLS", from, from.AddDate(0, 0, 14)); err != nil || len(events) != 1 || events[0].CodeEvent != "event-1" {
+		t.Fatalf("got %+v, %v", events, err)
+	}
+	if modules, err := client.Modules(ctx); err != nil || len(modules) != 1 || modules[0].Code != "B-CPE-100" {
+		t.Fatalf("got %+v, %v", modules, err)
+	}
+	activities, err := client.Module(ctx, 2026, "B-CPE-100", "TLS-1-1")
+	if err != nil || len(activities) != 1 {
+		t.Fatalf("got %+v, %v", activities, err)
+	}
+	if activities[0].CodeModule != "B-CPE-100" || activities[0].CodeInstance != "TLS-1-1" || activities[0].ScolarYear != "2026" {
+		t.Errorf("the codes of the module were not filled in: %+v", activities[0])
+	}
+	if project, err := client.ProjectGroup(ctx, 2026, "B-CPE-100", "TLS-1-1", "acti-1"); err != nil || project.Title != "Shell" {
+		t.Fatalf("got %+v, %v", project, err)
+	}
+
+	want := []string{
+		"/auth-0123abcd/planning/load?end=2026-10-15&format=json&location=FR%2FTLS&onlymymodule=true&onlymypromo=true&start=2026-10-01",
+		"/auth-0123abcd/course/filter?format=json",
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/?format=json",
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/acti-1/project/?format=json",
+	}
+	if strings.Join(requested, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requested\n%s\nwant\n%s", strings.Join(requested, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestClientErrorsHideTheAutologin(t *testing.T) {
+	requested := []string{}
+	server, client := newFakeIntra(t, &requested)
+	ctx := context.Background()
+
+	_, err := client.Module(ctx, 2026, "B-PSU-100", "TLS-1-1")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v, want a StatusError 404", err)
+	}
+	if statusErr.URL != server.URL+"/auth-***/module/2026/B-PSU-100/TLS-1-1/?format=json" {
+		t.Errorf("unexpected url %s", statusErr.URL)
+	}
+	errs := []error{err}
+	_, err = client.ProjectGroup(ctx, 2026, "B-CPE-100", "TLS-1-1", "acti-2") // invalid JSON
+	errs = append(errs, err)
+	server.Close()
+	_, err = client.Modules(ctx) // connection refused
+	errs = append(errs, err)
+
+	for _, err := range errs {
+		if err == nil || strings.Contains(err.Error(), "0123abcd") || !strings.Contains(err.Error(), "auth-***") {
+			t.Errorf("the autologin is not hidden from %v", err)
+		}
+	}
+}
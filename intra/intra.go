@@ -1,13 +1,11 @@
 package intra
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"regexp"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +18,7 @@ type Event struct {
 	CodeInstance       string      `json:"codeinstance"`
 	CodeActi           string      `json:"codeacti"`
 	CodeEvent          string      `json:"codeevent"`
+	ScolarYear         string      `json:"scolaryear"`
 	ModuleTitle        string      `json:"titlemodule"`
 	ActiTitle          string      `json:"acti_title"`
 	Start              string      `json:"start"`
@@ -60,8 +59,9 @@ type Activity struct {
 	CodeActi         string `json:"codeacti"`
 	Participants     []string
 	ParticipantsName []string
-	Update           bool   // for calendar update purposes. I know it's ugly to put it here. Sorry
-	ID               string // for calendar update purposes. I know it's ugly to put it here. Sorry
+	CodeModule       string // filled from the module the activity belongs to, the intra does not send it.
+	CodeInstance     string // filled from the module the activity belongs to, the intra does not send it.
+	ScolarYear       string // filled from the module the activity belongs to, the intra does not send it.
 }
 
 // Project is a struct that contains information about the project
@@ -91,35 +91,6 @@ type Room struct {
 	Seats int    `json:"seats"`
 }
 
-const intraURL = "https://intra.epitech.eu/"
-const intraTimeout = 10
-
-// getHTTPClient spawns a net/http client and returns it.
-func getHTTPClient() *http.Client {
-	httpClient := &http.Client{Timeout: intraTimeout * time.Second}
-	return httpClient
-}
-
-// getJSONResponse will execute a GET request then decode the answer as JSON.
-func getJSONResponse(client *http.Client, url string, target interface{}) error {
-	r, err := client.Get(url)
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
-
-	return json.NewDecoder(r.Body).Decode(target)
-}
-
-//EpitechTimeToRFC allows to convert the Epitech Timestamp to RFC 3339
-func EpitechTimeToRFC(timeStr string) string {
-	tmp := strings.Split(timeStr, " ")
-
-	newTime := tmp[0] + "T" + tmp[1] + "+01:00" // I know, it's hardCoded Location, sorry.
-
-	return newTime
-}
-
 //inArray checks if the integer passed in the first parameter is in the int array in the second parameter
 func inArray(val int, arr []int) bool {
 	for _, value := range arr {
@@ -163,110 +134,163 @@ func popEvent(tab *[]Event, idx int) {
 	}
 }
 
-//cleanRoomName will clean the room name of the event according to the regex in the config file.
-func cleanRoomName(events *[]Event, config *parser.Config) {
-	reg := regexp.MustCompile(config.LocationRegex)
+//cleanRoomName will clean the room name of the events with the regex, whose capture group is the room name.
+// The rooms the regex does not match are kept as is.
+func cleanRoomName(events *[]Event, roomRegex string) error {
+	reg, err := regexp.Compile(roomRegex)
+	if err != nil {
+		return fmt.Errorf("invalid location_regex: %v", err)
+	}
 	for index, event := range *events {
 		if (*events)[index].Room.Code == "" { // case where no room were provided in the activity
 			continue
 		}
-		(*events)[index].Room.Code = reg.FindStringSubmatch(event.Room.Code)[1]
+		if match := reg.FindStringSubmatch(event.Room.Code); len(match) > 1 {
+			(*events)[index].Room.Code = match[1]
+		}
 	}
+	return nil
 }
 
-// getCalendarRoute returns the calendar route appended with the auth token + the time
-func getCalendarRoute(auth string, loc string) string {
-	startTime := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
-	endTime := time.Now().AddDate(0, 2, 0).Format("2006-01-02")
-	url := fmt.Sprintf("%s%s/planning/load?format=json&location=%s&onlymypromo=true&onlymymodule=true&start=",
-		intraURL, auth, loc)
-	url += fmt.Sprintf("%s&end=%s", startTime, endTime)
+// PlanningWindow returns the period of the planning synchronised, from the config, in the timezone of the campus.
+// See parser.Config.SyncWindow. The agenda uses the same window in order to know which calendar events the intra is authoritative for.
+func PlanningWindow(conf *parser.Config) (time.Time, time.Time) {
+	loc, err := CampusLocation(conf)
+	if err != nil {
+		loc = time.Local
+	}
+	return conf.SyncWindow(time.Now().In(loc))
+}
 
-	return url
+// NewClientFromConfig creates a client of the intra from the intra_url and epitech_auth fields of the config.
+// The retries are logged with logger, the standard logger if nil.
+func NewClientFromConfig(conf *parser.Config, logger *log.Logger) (*Client, error) {
+	return NewClient(conf.IntraURL, conf.EpitechAuth, nil, logger)
 }
 
-// GetRegisteredEvents fetches the list of events registered on a two month period starting from tomorrow.
-func GetRegisteredEvents(conf *parser.Config, listEvents *[]Event) error {
-	httpClient := getHTTPClient()
-	url := getCalendarRoute(conf.EpitechAuth, conf.Location)
-	err := getJSONResponse(httpClient, url, listEvents)
+// RegisteredEvents fetches the events of the planning of the location the user is registered to, between start and end,
+// a window such as the one of PlanningWindow. The events that finished before start are left out, and their rooms are
+// cleaned with roomRegex, see the location_regex field of the config.
+func (c *Client) RegisteredEvents(ctx context.Context, location string, start time.Time, end time.Time, roomRegex string) ([]Event, error) {
+	events, err := c.Planning(ctx, location, start, end.Add(-time.Nanosecond)) // the intra end date is inclusive
 	if err != nil {
-		return err
+		return nil, err
 	}
-	trimUnregisteredEvents(listEvents)
-	err = trimFinishedEvents(listEvents)
-	if err != nil {
-		return err
+	trimUnregisteredEvents(&events)
+	trimFinishedEvents(&events, start, start.Location())
+	if err := cleanRoomName(&events, roomRegex); err != nil {
+		return nil, err
 	}
-	cleanRoomName(listEvents, conf)
-	return nil
+	return events, nil
 }
 
-// GetModules retrieves registered modules of the user
-func GetModules(conf *parser.Config, client *http.Client) (*[]Module, error) {
-	url := intraURL + conf.EpitechAuth + "/course/filter?format=json"
-	modules := []Module{}
+// FetchError is the failure to fetch a module, or the group of one of its projects when Acti is set
+type FetchError struct {
+	Year     string
+	Module   string
+	Instance string
+	Acti     string
+	Err      error
+}
 
-	err := getJSONResponse(client, url, &modules)
-	if err != nil {
-		return nil, err
+func (e *FetchError) Error() string {
+	if e.Acti != "" {
+		return fmt.Sprintf("group of project %s of module %s/%s/%s: %v", e.Acti, e.Year, e.Module, e.Instance, e.Err)
 	}
-	trimUselessModules(&modules, conf)
-	return &modules, nil
+	return fmt.Sprintf("module %s/%s/%s: %v", e.Year, e.Module, e.Instance, e.Err)
 }
 
-//GetProjects fetches the list of all the projects the user is registered to
-// goroutines are used in order to improve the performances.
-// After waiting for every module information to be sent to the channel, the said
-// channel is read until all projects are retrieved.
-func GetProjects(config *parser.Config, projects *[]Activity) error {
-	chanProjects := make(chan []Activity, 75) // a buffered channel is used and set to 75 because it hangs if unbuffered. I'm not proficient enough with channels to know how to avoid this.
-	client := getHTTPClient()
-	modules, err := GetModules(config, client)
-	var wg sync.WaitGroup
+// Unwrap returns the error of the request
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// FetchErrors gathers the failures of RegisteredProjects. The projects of the other modules are still returned.
+type FetchErrors []*FetchError
 
+func (e FetchErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d intra requests failed, the first one: %v", len(e), e[0])
+}
+
+// Failed tells if the module could not be fetched, or when acti is not empty, if the group of the project could not.
+func (e FetchErrors) Failed(year string, module string, instance string, acti string) bool {
+	for _, fetchErr := range e {
+		if fetchErr.Year == year && fetchErr.Module == module && fetchErr.Instance == instance &&
+			(fetchErr.Acti == "" || fetchErr.Acti == acti) {
+			return true
+		}
+	}
+	return false
+}
+
+//RegisteredProjects fetches the projects of the modules of the semesters the user is registered to,
+// leaving out the ones that ended before start. With participants, the members of the group of each project are
+// fetched as well. Goroutines are used in order to improve the performances, the client bounding the number of
+// concurrent requests. When some modules or project groups can't be fetched, the other projects are still
+// returned and the failures are returned as FetchErrors. The goroutines stop once ctx is done, its error is then returned.
+func (c *Client) RegisteredProjects(ctx context.Context, semesters []int, participants bool, start time.Time) ([]Activity, error) {
+	modules, err := c.Modules(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, module := range *modules {
+	trimUselessModules(&modules, semesters)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	projects := []Activity{}
+	fetchErrs := FetchErrors{}
+	for _, module := range modules {
 		wg.Add(1)
-		go GetModuleProjects(config, module, &chanProjects, &wg, client)
+		go func(module Module) {
+			defer wg.Done()
+			activities, errs := getModuleProjects(ctx, c, module, participants)
+			mu.Lock()
+			projects = append(projects, activities...)
+			fetchErrs = append(fetchErrs, errs...)
+			mu.Unlock()
+		}(module)
 	}
 	wg.Wait()
-	close(chanProjects)
-	for val := range chanProjects {
-		*projects = append(*projects, val...)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
-	trimEndedProjects(projects)
-	return nil
+	trimEndedProjects(&projects, start, start.Location())
+	if len(fetchErrs) != 0 {
+		return projects, fetchErrs
+	}
+	return projects, nil
 }
 
-// GetModuleProjects retrieves the projects for every modules
-func GetModuleProjects(conf *parser.Config, module Module, chanProjects *chan []Activity, wg *sync.WaitGroup, client *http.Client) {
-	url := intraURL + conf.EpitechAuth + "/module/"
-	url += strconv.Itoa(module.Scholaryear) + "/" + module.Code + "/"
-	url += module.Codeinstance
-	projects := &Activities{}
-
-	defer wg.Done()
-
-	err := getJSONResponse(client, url+"/?format=json", projects)
+// getModuleProjects retrieves the projects of the module with the failures of the requests
+func getModuleProjects(ctx context.Context, client *Client, module Module, participants bool) ([]Activity, FetchErrors) {
+	activities, err := client.Module(ctx, module.Scholaryear, module.Code, module.Codeinstance)
 	if err != nil {
-		log.Println(err)
+		return nil, FetchErrors{newFetchError(module, "", err)}
 	}
-	trimUselessActivities(projects)
-	if len(projects.Activities) != 0 {
-		if conf.ProjectParticipant {
-			addProjectParticipant(client, projects, url)
-		}
-		*chanProjects <- projects.Activities
+	trimUselessActivities(&activities)
+	if len(activities) != 0 && participants {
+		return activities, addProjectParticipant(ctx, client, module, activities)
 	}
+	return activities, nil
 }
 
-//trimUselessModules will remove every semester not in the epitech_semester config field.
-func trimUselessModules(modules *[]Module, conf *parser.Config) {
+func newFetchError(module Module, acti string, err error) *FetchError {
+	return &FetchError{
+		Year:     strconv.Itoa(module.Scholaryear),
+		Module:   module.Code,
+		Instance: module.Codeinstance,
+		Acti:     acti,
+		Err:      err,
+	}
+}
+
+//trimUselessModules will remove every semester not in the semesters, the epitech_semester config field.
+func trimUselessModules(modules *[]Module, semesters []int) {
 	for i := 0; i < len(*modules); i++ {
-		if !inArray((*modules)[i].Semester, conf.Semesters) || (*modules)[i].Registered == "notregistered" {
+		if !inArray((*modules)[i].Semester, semesters) || (*modules)[i].Registered == "notregistered" {
 			popModule(modules, i)
 			i--
 		}
@@ -274,37 +298,39 @@ func trimUselessModules(modules *[]Module, conf *parser.Config) {
 }
 
 //trimUselessActivities will remove every activities that are not projects.
-func trimUselessActivities(projects *Activities) {
-	for i := 0; i < len(projects.Activities); i++ {
-		if !projects.Activities[i].IsProject || (projects.Activities[i].TypeTitle != "Project" && projects.Activities[i].TypeTitle != "Mini-project") {
-			popActivity(&projects.Activities, i) // Technically we don't need to check typetitle. Unfortunately, I've seen
-			i--                                  // activities that are not projects or miniprojects but have  isProject set to true.
+func trimUselessActivities(activities *[]Activity) {
+	for i := 0; i < len(*activities); i++ {
+		if !(*activities)[i].IsProject || ((*activities)[i].TypeTitle != "Project" && (*activities)[i].TypeTitle != "Mini-project") {
+			popActivity(activities, i) // Technically we don't need to check typetitle. Unfortunately, I've seen
+			i--                        // activities that are not projects or miniprojects but have  isProject set to true.
 		}
 	}
 }
 
-//addProjectParticipant will retrieve teammate list for every projects and append it to the project informations
-func addProjectParticipant(client *http.Client, projects *Activities, url string) {
-	for index, val := range projects.Activities {
-		project := &Project{}
-		err := getJSONResponse(client, url+"/"+val.CodeActi+"/project/?format=json", project)
+//addProjectParticipant will retrieve teammate list for every projects and append it to the project informations.
+// The projects whose group can't be fetched are left without participants and their failures are returned.
+func addProjectParticipant(ctx context.Context, client *Client, module Module, activities []Activity) FetchErrors {
+	fetchErrs := FetchErrors{}
+	for index, val := range activities {
+		project, err := client.ProjectGroup(ctx, module.Scholaryear, module.Code, module.Codeinstance, val.CodeActi)
 		if err != nil {
-			log.Println(err)
-			return
+			fetchErrs = append(fetchErrs, newFetchError(module, val.CodeActi, err))
+			continue
 		}
 		for _, member := range project.Registered {
 			if member.Title != project.UserGroupName {
 				continue
 			}
-			projects.Activities[index].Participants = append(projects.Activities[index].Participants, member.Master.Login)
-			projects.Activities[index].ParticipantsName = append(projects.Activities[index].ParticipantsName, member.Master.Name)
+			activities[index].Participants = append(activities[index].Participants, member.Master.Login)
+			activities[index].ParticipantsName = append(activities[index].ParticipantsName, member.Master.Name)
 			for _, name := range member.Members {
-				projects.Activities[index].Participants = append(projects.Activities[index].Participants, name.Login)
-				projects.Activities[index].ParticipantsName = append(projects.Activities[index].ParticipantsName, name.Name)
+				activities[index].Participants = append(activities[index].Participants, name.Login)
+				activities[index].ParticipantsName = append(activities[index].ParticipantsName, name.Name)
 			}
 			break
 		}
 	}
+	return fetchErrs
 }
 
 // trimUnregisteredEvents removes events that we did not register to. those events are not supposed to
@@ -323,30 +349,27 @@ func trimUnregisteredEvents(listEvents *[]Event) {
 	*listEvents = (*listEvents)[:i]
 }
 
-//trimEndedProjects removes projects that ended before the program is run
-func trimEndedProjects(projects *[]Activity) {
-	now := time.Now()
+//trimEndedProjects removes projects that ended before the start of the planning window.
+// The projects with a malformed end are kept: the agenda skips them, leaving their calendar event as it is.
+func trimEndedProjects(projects *[]Activity, start time.Time, loc *time.Location) {
 	for i := 0; i < len(*projects); i++ {
-		endTime, _ := time.Parse(time.RFC3339, EpitechTimeToRFC((*projects)[i].End))
-		if now.After(endTime) {
+		endTime, err := ParseTime((*projects)[i].End, loc)
+		if err == nil && !endTime.After(start) {
 			popActivity(projects, i)
 			i--
 		}
 	}
 }
 
-//trimFinishedEvents removes event happening the same day the program is run but that already finished
-func trimFinishedEvents(listEvents *[]Event) error {
-	now := time.Now()
+//trimFinishedEvents removes events that finished before the start of the planning window,
+// such as the events of the first day that already finished when the window starts now.
+// The events with a malformed end are kept: the agenda skips them, leaving their calendar event as it is.
+func trimFinishedEvents(listEvents *[]Event, start time.Time, loc *time.Location) {
 	for i := 0; i < len(*listEvents); i++ {
-		t, err := time.Parse(time.RFC3339, EpitechTimeToRFC((*listEvents)[i].Start))
-		if err != nil {
-			return err
-		}
-		if now.After(t) {
+		t, err := ParseTime((*listEvents)[i].End, loc)
+		if err == nil && !t.After(start) {
 			popEvent(listEvents, i)
 			i-- // prevent itteration as we popped the current i
 		}
 	}
-	return nil
 }
@@ -0,0 +1,114 @@
+package intra
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// windowStart is the start of the planning window of the tests: noon in Toulouse
+func windowStart(t *testing.T) time.Time {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Date(2026, 10, 19, 12, 0, 0, 0, loc)
+}
+
+func TestRegisteredEvents(t *testing.T) {
+	requested := []string{}
+	server, client := serveIntra(t, map[string]string{
+		"/auth-0123abcd/planning/load": `[
+			{"codeacti":"acti-1","start":"2026-10-19 09:00:00","end":"2026-10-19 11:00:00","event_registered":"registered"},
+			{"codeacti":"acti-2","start":"2026-10-19 13:00:00","end":"2026-10-19 15:00:00","event_registered":"registered","room":{"code":"FR/TLS/Marquette/703"}},
+			{"codeacti":"acti-3","start":"2026-10-20 09:00:00","end":"2026-10-20 11:00:00","event_registered":false},
+			{"codeacti":"acti-4","start":"2026-10-20 09:00:00","end":"2026-10-20 11:00:00","event_registered":"present","room":{"code":"Hub"}}
+		]`,
+	}, &requested)
+	defer server.Close()
+	start := windowStart(t)
+
+	events, err := client.RegisteredEvents(context.Background(), "FR/TLS", start, start.AddDate(0, 0, 2), `\w{2}\/\w+\/\w+\/([\w-_]+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms := map[string]string{}
+	for _, ev := range events { // the trims do not keep the order
+		rooms[ev.CodeActi] = ev.Room.Code
+	}
+	if len(events) != 2 || rooms["acti-2"] != "703" || rooms["acti-4"] != "Hub" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	want := "/auth-0123abcd/planning/load?end=2026-10-21&format=json&location=FR%2FTLS&onlymymodule=true&onlymypromo=true&start=2026-10-19"
+	if len(requested) != 1 || requested[0] != want {
+		t.Errorf("requested %v, want %s", requested, want)
+	}
+}
+
+func TestRegisteredProjects(t *testing.T) {
+	requested := []string{}
+	server, client := serveIntra(t, map[string]string{
+		"/auth-0123abcd/course/filter": `[
+			{"code":"B-CPE-100","codeinstance":"TLS-1-1","scolaryear":2026,"semester":1,"status":"ongoing"},
+			{"code":"B-PSU-100","codeinstance":"TLS-1-1","scolaryear":2026,"semester":1,"status":"notregistered"},
+			{"code":"B-PSU-200","codeinstance":"TLS-2-1","scolaryear":2026,"semester":2,"status":"ongoing"},
+			{"code":"B-MAT-100","codeinstance":"TLS-1-1","scolaryear":2026,"semester":1,"status":"ongoing"}
+		]`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/": `{"activites":[
+			{"codeacti":"acti-1","title":"Shell","type_title":"Project","is_projet":true,"begin":"2026-10-01 08:00:00","end":"2026-10-30 23:42:00"},
+			{"codeacti":"acti-2","title":"Bootstrap","type_title":"Project","is_projet":true,"begin":"2026-09-01 08:00:00","end":"2026-09-30 23:42:00"},
+			{"codeacti":"acti-3","title":"Kick-off","type_title":"Class","is_projet":false,"begin":"2026-10-01 08:00:00","end":"2026-10-30 23:42:00"}
+		]}`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/acti-1/project/": `{"title":"Shell","user_project_title":"team",
+			"registered":[{"title":"other","master":{"login":"eve@epitech.eu","title":"Eve"}},
+			{"title":"team","master":{"login":"alice@epitech.eu","title":"Alice"},"members":[{"login":"bob@epitech.eu","title":"Bob"}]}]}`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/acti-2/project/": `{"title":"Bootstrap","registered":[]}`,
+	}, &requested)
+	defer server.Close()
+
+	projects, err := client.RegisteredProjects(context.Background(), []int{1}, true, windowStart(t))
+	var fetchErrs FetchErrors
+	if !errors.As(err, &fetchErrs) || len(fetchErrs) != 1 || fetchErrs[0].Module != "B-MAT-100" {
+		t.Fatalf("got %v, want the failure of B-MAT-100", err)
+	}
+	if len(projects) != 1 || projects[0].CodeActi != "acti-1" || projects[0].CodeModule != "B-CPE-100" {
+		t.Fatalf("unexpected projects %+v", projects)
+	}
+	if len(projects[0].Participants) != 2 || projects[0].ParticipantsName[1] != "Bob" {
+		t.Errorf("unexpected group %v %v", projects[0].Participants, projects[0].ParticipantsName)
+	}
+	for _, path := range requested {
+		if path == "/auth-0123abcd/module/2026/B-PSU-100/TLS-1-1/?format=json" || path == "/auth-0123abcd/module/2026/B-PSU-200/TLS-2-1/?format=json" {
+			t.Errorf("fetched %s, which is not registered or not in the semesters", path)
+		}
+	}
+}
+
+func TestMalformedEndsAreLeftToTheAgenda(t *testing.T) {
+	requested := []string{}
+	server, client := serveIntra(t, map[string]string{
+		"/auth-0123abcd/planning/load": `[
+			{"codeacti":"acti-1","start":"2026-10-20 09:00:00","end":"soon","event_registered":"registered"},
+			{"codeacti":"acti-2","start":"2026-10-20 13:00:00","end":"2026-10-20 15:00:00","event_registered":"registered"}
+		]`,
+		"/auth-0123abcd/course/filter": `[{"code":"B-CPE-100","codeinstance":"TLS-1-1","scolaryear":2026,"semester":1}]`,
+		"/auth-0123abcd/module/2026/B-CPE-100/TLS-1-1/": `{"activites":[
+			{"codeacti":"acti-10","title":"Shell","type_title":"Project","is_projet":true,"begin":"2026-10-01 08:00:00","end":""},
+			{"codeacti":"acti-11","title":"Bootstrap","type_title":"Project","is_projet":true,"begin":"2026-09-01 08:00:00","end":"2026-09-30 23:42:00"}
+		]}`,
+	}, &requested)
+	defer server.Close()
+	ctx := context.Background()
+	start := windowStart(t)
+
+	// the agenda skips them and keeps their calendar event, rather than deleting it or failing the synchronisation
+	events, err := client.RegisteredEvents(ctx, "FR/TLS", start, start.AddDate(0, 0, 2), `(\w+)`)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("got %+v, %v, want both events", events, err)
+	}
+	projects, err := client.RegisteredProjects(ctx, []int{1}, false, start)
+	if err != nil || len(projects) != 1 || projects[0].CodeActi != "acti-10" {
+		t.Fatalf("got %+v, %v, want the project with a malformed end", projects, err)
+	}
+}
@@ -0,0 +1,109 @@
+package intra
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// TimeLayout is the layout of the timestamps of the intra. They carry no offset: they are local to the campus.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// defaultTimezone is the timezone of the campuses whose location code is unknown
+const defaultTimezone = "Europe/Paris"
+
+// campusTimezones are the timezones of the location codes, the most precise code first.
+// Campuses without their own entry get the timezone of their country.
+var campusTimezones = []struct {
+	code     string
+	timezone string
+}{
+	{"FR/RUN", "Indian/Reunion"},
+	{"FR", "Europe/Paris"},
+	{"BE", "Europe/Brussels"},
+	{"DE", "Europe/Berlin"},
+	{"ES", "Europe/Madrid"},
+	{"AL", "Europe/Tirane"},
+	{"BJ", "Africa/Porto-Novo"},
+	{"MU", "Indian/Mauritius"},
+	{"CO", "America/Bogota"},
+}
+
+var (
+	locationsMu sync.Mutex
+	locations   = map[string]*time.Location{}
+)
+
+// loadLocation loads the timezone once and keeps it for the next calls
+func loadLocation(name string) (*time.Location, error) {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// CampusTimezone returns the name of the timezone of the campus: the timezone field of the config,
+// or the timezone of the epitech_location_code when it is empty.
+func CampusTimezone(conf *parser.Config) string {
+	if conf.Timezone != "" {
+		return conf.Timezone
+	}
+	for _, campus := range campusTimezones {
+		if conf.Location == campus.code || strings.HasPrefix(conf.Location, campus.code+"/") {
+			return campus.timezone
+		}
+	}
+	return defaultTimezone
+}
+
+// CampusLocation loads the timezone of the campus, see CampusTimezone
+func CampusLocation(conf *parser.Config) (*time.Location, error) {
+	return loadLocation(CampusTimezone(conf))
+}
+
+// ParseTime parses a timestamp of the intra in the timezone of the campus
+func ParseTime(value string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(TimeLayout, strings.TrimSpace(value), loc)
+}
+
+// EventTime returns the start and end of the event, or of the slot the user registered to for appointments:
+// the rdv_group_registered or rdv_indiv_registered field, "start|end".
+func EventTime(ev Event, loc *time.Location) (time.Time, time.Time, error) {
+	start, end := ev.Start, ev.End
+	slot := ev.RdvGroupRegistered
+	if slot == "" {
+		slot = ev.RdvIndivRegistered
+	}
+	if bounds := strings.Split(slot, "|"); len(bounds) == 2 {
+		start, end = bounds[0], bounds[1]
+	}
+	return parseBounds(start, end, loc)
+}
+
+// ActivityTime returns the begin and end of the activity
+func ActivityTime(ev Activity, loc *time.Location) (time.Time, time.Time, error) {
+	return parseBounds(ev.Begin, ev.End, loc)
+}
+
+func parseBounds(start string, end string, loc *time.Location) (time.Time, time.Time, error) {
+	startTime, err := ParseTime(start, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start %q", start)
+	}
+	endTime, err := ParseTime(end, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end %q", end)
+	}
+	return startTime, endTime, nil
+}
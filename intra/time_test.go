@@ -0,0 +1,94 @@
+package intra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+func TestEventTime(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.UTC // the process timezone must not matter, only the one of the campus
+
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(TimeLayout, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		name       string
+		location   string
+		event      Event
+		start, end time.Time // in UTC, zero for a malformed event
+	}{
+		{"summer time in Toulouse", "FR/TLS",
+			Event{Start: "2026-06-15 09:00:00", End: "2026-06-15 12:00:00"},
+			utc("2026-06-15 07:00:00"), utc("2026-06-15 10:00:00")},
+		{"winter time in Toulouse", "FR/TLS",
+			Event{Start: "2026-12-15 09:00:00", End: "2026-12-15 12:00:00"},
+			utc("2026-12-15 08:00:00"), utc("2026-12-15 11:00:00")},
+		{"spring forward in Paris", "FR/PAR",
+			Event{Start: "2026-03-29 01:00:00", End: "2026-03-29 04:00:00"},
+			utc("2026-03-29 00:00:00"), utc("2026-03-29 02:00:00")},
+		{"fall back in Paris", "FR/PAR",
+			Event{Start: "2026-10-25 01:00:00", End: "2026-10-25 04:00:00"},
+			utc("2026-10-24 23:00:00"), utc("2026-10-25 03:00:00")},
+		{"Reunion campus", "FR/RUN",
+			Event{Start: "2026-10-25 01:00:00", End: "2026-10-25 04:00:00"},
+			utc("2026-10-24 21:00:00"), utc("2026-10-25 00:00:00")},
+		{"group appointment", "FR/TLS",
+			Event{Start: "2026-10-20 14:00:00", End: "2026-10-20 18:00:00", RdvGroupRegistered: "2026-10-20 15:00:00|2026-10-20 15:20:00"},
+			utc("2026-10-20 13:00:00"), utc("2026-10-20 13:20:00")},
+		{"individual appointment", "FR/TLS",
+			Event{Start: "2026-10-20 14:00:00", End: "2026-10-20 18:00:00", RdvIndivRegistered: "2026-10-20 16:40:00|2026-10-20 17:00:00"},
+			utc("2026-10-20 14:40:00"), utc("2026-10-20 15:00:00")},
+		{"appointment without slot", "FR/TLS",
+			Event{Start: "2026-10-20 14:00:00", End: "2026-10-20 18:00:00", RdvGroupRegistered: "none"},
+			utc("2026-10-20 12:00:00"), utc("2026-10-20 16:00:00")},
+		{"malformed start", "FR/TLS",
+			Event{Start: "2026-10-20", End: "2026-10-20 18:00:00"}, time.Time{}, time.Time{}},
+		{"malformed end", "FR/TLS",
+			Event{Start: "2026-10-20 14:00:00", End: ""}, time.Time{}, time.Time{}},
+		{"malformed slot", "FR/TLS",
+			Event{Start: "2026-10-20 14:00:00", End: "2026-10-20 18:00:00", RdvIndivRegistered: "15:00|15:20"}, time.Time{}, time.Time{}},
+	}
+	for _, test := range tests {
+		loc, err := CampusLocation(&parser.Config{Location: test.location})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end, err := EventTime(test.event, loc)
+		if test.start.IsZero() {
+			if err == nil {
+				t.Errorf("%s: got %v to %v, want an error", test.name, start, end)
+			}
+			continue
+		}
+		if err != nil || !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Errorf("%s: got %v to %v (%v), want %v to %v", test.name, start.UTC(), end.UTC(), err, test.start, test.end)
+		}
+	}
+}
+
+func TestCampusTimezone(t *testing.T) {
+	tests := []struct {
+		location string
+		timezone string
+		want     string
+	}{
+		{"FR/TLS", "", "Europe/Paris"},
+		{"FR/RUN", "", "Indian/Reunion"},
+		{"FR/RUN/Saint-Andre", "", "Indian/Reunion"},
+		{"XX/ABC", "", "Europe/Paris"}, // unknown country
+		{"BE/BRU", "", "Europe/Brussels"},
+		{"FR/TLS", "UTC", "UTC"},
+	}
+	for _, test := range tests {
+		if got := CampusTimezone(&parser.Config{Location: test.location, Timezone: test.timezone}); got != test.want {
+			t.Errorf("CampusTimezone(%s, %q) = %s, want %s", test.location, test.timezone, got, test.want)
+		}
+	}
+}
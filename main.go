@@ -1,38 +1,251 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/nheuillet/calendar-linker/agenda"
 	"github.com/nheuillet/calendar-linker/intra"
 	"github.com/nheuillet/calendar-linker/parser"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	configPath      = flag.String("config", "", "path of the config file. Default is $"+parser.ConfigEnv+", then config.json in the config directory, then in the working directory")
+	credentialsPath = flag.String("credentials", "", "path of the google client secret file, overrides credentials_file")
+	tokenPath       = flag.String("token", "", "path of the file the oauth token is saved to, overrides token_file")
+	profileName     = flag.String("profile", "", "only use this profile of the config. sync and daemon use every profile by default")
+	verbose         = flag.Bool("v", false, "log every change done to the calendars")
+	timeout         = flag.Duration("timeout", 0, "abort a synchronisation lasting longer than this duration, eg: 5m. 0 means no limit")
+)
+
 func handleErrors(err error) {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
 
-func main() {
-	projects := &[]intra.Activity{}
-	registeredEvents := &[]intra.Event{}
-	config, err := parser.GetConfigInfos()
-	handleErrors(err)
-	err = intra.GetRegisteredEvents(config, registeredEvents)
-	handleErrors(err)
+// readConfig finds the config file, from the command line, the environment or the default locations, and reads it
+func readConfig() (*parser.Config, error) {
+	path, err := parser.FindConfig(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	*configPath = path
+	return parser.GetConfigInfos(path)
+}
+
+// loadProfiles reads the config file and validates it, then returns the profile given with -profile, or every
+// profile of the config. The config itself is the only profile of a config without profiles.
+// The paths of the calendar credentials of the profiles are set from the command line or their default.
+func loadProfiles() ([]*parser.Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, invalidConfig(err)
+	}
+	profiles := config.Profiles()
+	if *profileName != "" {
+		profile, err := config.Profile(*profileName)
+		if err != nil {
+			return nil, err
+		}
+		profiles = []*parser.Config{profile}
+	}
+	if *tokenPath != "" && len(profiles) > 1 {
+		return nil, errors.New("-token can't be shared by several profiles, set the token_file of each profile or choose one with -profile")
+	}
+	for _, profile := range profiles {
+		profile.CredentialsFile = dataFile(*credentialsPath, profile.CredentialsFile, parser.DataFile("credentials.json"))
+		profile.TokenFile = dataFile(*tokenPath, profile.TokenFile, profile.DataFile("token.json"))
+		if fields := profile.OverriddenSecrets(); len(fields) != 0 {
+			log.Printf("The secret store overrides %s of %s: remove these fields from %s, after running migrate-secrets if they changed\n",
+				strings.Join(fields, ", "), profileLabel(profile), *configPath)
+		}
+	}
+	return profiles, nil
+}
+
+// loadConfig returns the config of the single profile the command runs with, see loadProfiles
+func loadConfig() (*parser.Config, error) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) > 1 {
+		names := []string{}
+		for _, profile := range profiles {
+			names = append(names, profile.Name)
+		}
+		return nil, fmt.Errorf("the config has several profiles, choose one with -profile: %s", strings.Join(names, ", "))
+	}
+	return profiles[0], nil
+}
+
+// dataFile returns the path given on the command line, else the one of the config file, else the default one
+func dataFile(flagPath string, configPath string, defaultPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if configPath != "" {
+		return configPath
+	}
+	return defaultPath
+}
+
+// profileContext returns a context the logs of the profile are prefixed with its name in
+func profileContext(ctx context.Context, config *parser.Config) context.Context {
+	if config.Name == "" {
+		return ctx
+	}
+	return agenda.WithLogger(ctx, log.New(log.Writer(), "["+config.Name+"] ", log.Flags()|log.Lmsgprefix))
+}
+
+// invalidConfig describes the problems of the config file, one per line
+func invalidConfig(problems error) error {
+	return fmt.Errorf("%s is invalid:\n  %v", *configPath, problems)
+}
+
+// interruptContext returns a context cancelled on SIGINT or SIGTERM: the fetches in progress are abandoned
+// and the calendar changes in progress are completed. A second signal exits immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, finishing the calendar changes in progress. Send it again to exit now\n", sig)
+		cancel()
+		sig = <-signals
+		log.Printf("Received %v again, exiting\n", sig)
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// runContext bounds a synchronisation with the -timeout flag
+func runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, *timeout)
+}
 
+// fetchPlanning retrieves the registered events and, if create_project_event is enabled, the projects from the intra.
+// projects is nil when the project events are disabled. With the skip_deletions policy, the modules and project
+// groups that can't be fetched are returned in fetchErrs, the other projects are still returned.
+func fetchPlanning(ctx context.Context, config *parser.Config) (*[]intra.Event, *[]intra.Activity, intra.FetchErrors, error) {
+	var projects *[]intra.Activity
+	var fetchErrs intra.FetchErrors
+
+	client, err := intra.NewClientFromConfig(config, agenda.Logger(ctx))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	registeredEvents, err := fetchEvents(ctx, client, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	if config.ProjectEvent {
 		// if ProjectEvent is set to True then it will fetch all the modules
 		// in order to retrieve the Project information, then it will add it to
 		// the agenda.
 		// WARNING: fetching every project is very long due to the very bad REST api of the intra..
 		// UPDATE: it is  no longer long. Going for a freaking huge amount of goroutine does the trick. Intra Api is still very bad.
-		err = intra.GetProjects(config, projects)
-		handleErrors(err)
+		projects, err = fetchProjects(ctx, client, config)
+		if errs, ok := err.(intra.FetchErrors); ok && config.FetchErrorPolicy != parser.PolicyFail {
+			fetchErrs, err = errs, nil
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return &registeredEvents, projects, fetchErrs, nil
+}
+
+// fetchEvents retrieves the events of the planning window of the config the user is registered to
+func fetchEvents(ctx context.Context, client *intra.Client, config *parser.Config) ([]intra.Event, error) {
+	start, end := intra.PlanningWindow(config)
+	return client.RegisteredEvents(ctx, config.Location, start, end, config.LocationRegex)
+}
+
+// fetchProjects retrieves the projects of the semesters of the config, with their group when
+// add_participants_to_project is set. The projects are returned along with the FetchErrors.
+func fetchProjects(ctx context.Context, client *intra.Client, config *parser.Config) (*[]intra.Activity, error) {
+	start, _ := intra.PlanningWindow(config)
+	projects, err := client.RegisteredProjects(ctx, config.Semesters, config.ProjectParticipant, start)
+	return &projects, err
+}
+
+// logRunReport logs what was fetched from the intra and the requests that failed
+func logRunReport(ctx context.Context, events *[]intra.Event, projects *[]intra.Activity, fetchErrs intra.FetchErrors) {
+	logger := agenda.Logger(ctx)
+	projectCount := 0
+	if projects != nil {
+		projectCount = len(*projects)
+	}
+	logger.Printf("Intra: %d events and %d projects fetched\n", len(*events), projectCount)
+	if len(fetchErrs) == 0 {
+		return
+	}
+	logger.Printf("Intra: %d requests failed, the project events of those modules are kept as they are:\n", len(fetchErrs))
+	for _, fetchErr := range fetchErrs {
+		logger.Printf("  %v\n", fetchErr)
+	}
+}
+
+// synchronise fetches the planning from the intra and synchronises it with the calendar, within the -timeout duration
+func synchronise(ctx context.Context, config *parser.Config, backend agenda.CalendarBackend) error {
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	registeredEvents, projects, fetchErrs, err := fetchPlanning(ctx, config)
+	if err != nil {
+		return err
 	}
-	googleClient := agenda.GetGoogleClient()
+	err = agenda.CreateEvents(ctx, backend, config, registeredEvents, projects, fetchErrs)
+	logRunReport(ctx, registeredEvents, projects, fetchErrs)
+	return err
+}
 
-	agenda.CreateEvents(googleClient, config,
-		registeredEvents, projects)
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [command flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(out, "\nWithout command, sync is run.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	agenda.Verbose = *verbose
+
+	name := "sync"
+	args := []string{}
+	if flag.NArg() > 0 {
+		name = flag.Arg(0)
+		args = flag.Args()[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			handleErrors(cmd.run(interruptContext(), args))
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	flag.Usage()
+	os.Exit(2)
 }
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigExtensions are the extensions of the config formats, the JSON one first
+var ConfigExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// toJSON converts a YAML or TOML config, chosen by the extension of path, to JSON so that every format is decoded
+// with the same field names and checks. A JSON config is returned as is.
+func toJSON(path string, data []byte) ([]byte, error) {
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("could not read yaml, %s: %v", path, err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &values); err != nil {
+			return nil, fmt.Errorf("could not read toml, %s: %v", path, err)
+		}
+	default:
+		return data, nil
+	}
+	return json.Marshal(normalize(values))
+}
+
+// normalize converts the values JSON can't encode as the config expects them: the dates of TOML become YYYY-MM-DD
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			value[key] = normalize(field)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalize(item)
+		}
+	case time.Time:
+		return value.Format("2006-01-02")
+	}
+	return value
+}
+
+// decodeConfig decodes the JSON config into conf, rejecting the unknown fields.
+// source is the original config, used to locate the errors of a JSON config.
+func decodeConfig(path string, source []byte, data []byte, conf *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(conf)
+	if err == nil {
+		return nil
+	}
+	location := path
+	isJSON := bytes.Equal(source, data)
+	if syntaxErr, ok := err.(*json.SyntaxError); ok && isJSON {
+		location = fmt.Sprintf("%s line %d", path, lineOf(source, syntaxErr.Offset))
+	}
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		if isJSON {
+			location = fmt.Sprintf("%s line %d", path, lineOf(source, typeErr.Offset))
+		}
+		if typeErr.Field == "" {
+			return fmt.Errorf("could not read %s: expected the fields of the config, found a %s", location, typeErr.Value)
+		}
+		return fmt.Errorf("could not read %s: %s: expected a %v, found a %s", location, typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return fmt.Errorf("could not read %s: unknown field %q%s", path, field, suggestField(field))
+	}
+	return fmt.Errorf("could not read %s, make sure it is in a correct format: %v", location, err)
+}
+
+// lineOf returns the line of the byte at offset in data, starting at 1
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// suggestField returns a hint naming the field closest to the unknown one, if it looks like a typo
+func suggestField(unknown string) string {
+	best, bestDistance := "", len(unknown)/2+1
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]
+		if field == "" || field == "-" {
+			continue
+		}
+		if distance := editDistance(unknown, field); distance < bestDistance {
+			best, bestDistance = field, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormats(t *testing.T) {
+	want := &Config{
+		GoogleCalendarEvents:   "events@group.calendar.google.com",
+		GoogleCalendarProjects: DefaultCalendar,
+		EpitechAuth:            "https://intra.epitech.eu/auth-0123abcd",
+		Location:               "FR/TLS",
+		ProjectEvent:           true,
+		Semesters:              []int{1, 2},
+		Reminders:              []int{},
+		SyncFrom:               "2026-09-01",
+	}
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.json", `{
+			"google_calendar_events": "events@group.calendar.google.com",
+			"epitech_auth": "https://intra.epitech.eu/auth-0123abcd",
+			"epitech_location_code": "FR/TLS",
+			"create_project_event": true,
+			"epitech_semesters": [1, 2],
+			"reminder_time": [],
+			"sync_from": "2026-09-01"
+		}`},
+		{"config.yaml", `
+google_calendar_events: events@group.calendar.google.com
+epitech_auth: https://intra.epitech.eu/auth-0123abcd
+epitech_location_code: FR/TLS
+create_project_event: true
+epitech_semesters: [1, 2]
+reminder_time: []
+sync_from: "2026-09-01"
+`},
+		{"config.yml", `
+google_calendar_events: events@group.calendar.google.com
+epitech_auth: https://intra.epitech.eu/auth-0123abcd
+epitech_location_code: FR/TLS
+create_project_event: true
+epitech_semesters:
+  - 1
+  - 2
+reminder_time: []
+sync_from: 2026-09-01
+`},
+		{"config.toml", `
+google_calendar_events = "events@group.calendar.google.com"
+epitech_auth = "https://intra.epitech.eu/auth-0123abcd"
+epitech_location_code = "FR/TLS"
+create_project_event = true
+epitech_semesters = [1, 2]
+reminder_time = []
+sync_from = 2026-09-01
+`},
+	}
+	for _, test := range tests {
+		conf, err := GetConfigInfos(writeConfig(t, test.name, test.content))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := &Config{
+			GoogleCalendarEvents:   conf.GoogleCalendarEvents,
+			GoogleCalendarProjects: conf.GoogleCalendarProjects,
+			EpitechAuth:            conf.EpitechAuth,
+			Location:               conf.Location,
+			ProjectEvent:           conf.ProjectEvent,
+			Semesters:              conf.Semesters,
+			Reminders:              conf.Reminders,
+			SyncFrom:               conf.SyncFrom,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	tests := []struct {
+		name                        string
+		content                     string
+		eventsCalendar, projectsCal string
+		eventColor, projectColor    string
+	}{
+		{"google", `{}`, DefaultCalendar, DefaultCalendar, DefaultEventColor, DefaultProjectColor},
+		{"google events calendar", `{"google_calendar_events": "linker"}`, "linker", DefaultCalendar, DefaultEventColor, DefaultProjectColor},
+		{"outlook", `{"calendar_backend": "outlook"}`, DefaultCalendar, DefaultCalendar, "", ""},
+		{"caldav", `{"calendar_backend": "caldav"}`, "", "", "", ""},
+		{"caldav projects in the events calendar", `{"calendar_backend": "caldav", "google_calendar_events": "epitech"}`, "epitech", "epitech", "", ""},
+		{"colors set", `{"event_color": "5", "project_color": "7"}`, DefaultCalendar, DefaultCalendar, "5", "7"},
+	}
+	for _, test := range tests {
+		conf, err := GetConfigInfos(writeConfig(t, "config.json", test.content))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if conf.GoogleCalendarEvents != test.eventsCalendar || conf.GoogleCalendarProjects != test.projectsCal {
+			t.Errorf("%s: got the calendars %q and %q, want %q and %q", test.name,
+				conf.GoogleCalendarEvents, conf.GoogleCalendarProjects, test.eventsCalendar, test.projectsCal)
+		}
+		if conf.EventColor != test.eventColor || conf.ProjectColor != test.projectColor {
+			t.Errorf("%s: got the colors %q and %q, want %q and %q", test.name,
+				conf.EventColor, conf.ProjectColor, test.eventColor, test.projectColor)
+		}
+		if conf.LocationRegex != DefaultLocationRegex || !reflect.DeepEqual(conf.Reminders, DefaultReminders) || conf.SecretFile == "" {
+			t.Errorf("%s: got the regex %q, the reminders %v and the secret file %q, want the defaults", test.name,
+				conf.LocationRegex, conf.Reminders, conf.SecretFile)
+		}
+	}
+}
+
+func TestConfigUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string // the end of the error, empty when the config is valid
+	}{
+		{"config.json", `{"epitech_location_code": "FR/TLS"}`, ""},
+		{"config.json", `{"epitech_loaction_code": "FR/TLS"}`, `unknown field "epitech_loaction_code", did you mean "epitech_location_code"?`},
+		{"config.json", `{"reminders": [10]}`, `unknown field "reminders"`},
+		{"config.yaml", "sync_intervall: 30\n", `unknown field "sync_intervall", did you mean "sync_interval"?`},
+		{"config.toml", "calendar_backnd = \"caldav\"\n", `unknown field "calendar_backnd", did you mean "calendar_backend"?`},
+		{"config.json", `{"profiles": {"alice": {"epitech_semester": [1]}}}`, `unknown field "epitech_semester", did you mean "epitech_semesters"?`},
+		{"config.json", `{"color": "3"}`, `unknown field "color"`}, // too far from any field for a suggestion
+	}
+	for _, test := range tests {
+		_, err := GetConfigInfos(writeConfig(t, test.name, test.content))
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want the config to be read", test.content, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error ending with %s", test.content, err, test.want)
+		}
+	}
+}
+
+func TestSuggestField(t *testing.T) {
+	tests := []struct {
+		unknown string
+		want    string
+	}{
+		{"timezon", "timezone"},
+		{"epitech_semester", "epitech_semesters"},
+		{"Backend", ""}, // too far from calendar_backend
+		{"zzz", ""},
+		{"profile", "profiles"},
+	}
+	for _, test := range tests {
+		got := suggestField(test.unknown)
+		if test.want == "" && got != "" || test.want != "" && got != `, did you mean "`+test.want+`"?` {
+			t.Errorf("suggestField(%q) = %q, want a suggestion of %q", test.unknown, got, test.want)
+		}
+	}
+}
@@ -2,8 +2,9 @@ package parser
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io/ioutil"
+	"time"
 )
 
 // Config structure that holds the data provided by the user in the configuration file
@@ -11,28 +12,152 @@ type Config struct {
 	GoogleCalendarEvents   string `json:"google_calendar_events"`      // the calendar id of daily events. default calendar is "Primary"
 	GoogleCalendarProjects string `json:"google_calendar_projects"`    // the calendar id of the project events. You can use the same id as the upper field. default calendar is "Primary"
 	EpitechAuth            string `json:"epitech_auth"`                // the autologin token. Starts with "auth-"
+	IntraURL               string `json:"intra_url"`                   // the url of the intranet. Default is https://intra.epitech.eu
 	Location               string `json:"epitech_location_code"`       // Location code on the intra. Eg: FR/TLS for Toulouse (<3)
 	ProjectEvent           bool   `json:"create_project_event"`        // If you want to create the project events on your calendar
 	ProjectParticipant     bool   `json:"add_participants_to_project"` // Turning it to true adds participants to the project. Caution: leads to N * More call to the API, N being the number of projects.
 	Semesters              []int  `json:"epitech_semesters"`           // Semesters you want to scan if ProjectEvent set to true.
-	Timezone               string `json:"timezone"`                    // The timezone of the epitech you are enrolled in
+	Timezone               string `json:"timezone"`                    // The timezone of the epitech you are enrolled in, derived from Location when empty
 	ProjectColor           string `json:"project_color"`               // see https://lukeboyle.com/blog-posts/2016/04/google-calendar-api---color-id
 	EventColor             string `json:"event_color"`                 // see https://lukeboyle.com/blog-posts/2016/04/google-calendar-api---color-id
 	Reminders              []int  `json:"reminder_time"`               // Array Number of minutes in order to get a notification. Max is 40320 per google api recommendation(4 weeks in minutes)
 	LocationRegex          string `json:"location_regex"`              // The regex used to extract the name of the room. Using a regex in order to make sure it is customizable for every Epitech. Epitech Toulouse example is FR/TLS/Marquette/ROOMNAME
+	Backend                string `json:"calendar_backend"`            // The calendar the events are synchronised to: "google" (default), "caldav" or "outlook".
+	CalDAVURL              string `json:"caldav_url"`                  // The CalDAV server url. The calendar fields are then calendar names or collection paths.
+	CalDAVUsername         string `json:"caldav_username"`             // The CalDAV user
+	CalDAVPassword         string `json:"caldav_password"`             // The CalDAV password. An app password is recommended.
+	OutlookClientID        string `json:"outlook_client_id"`           // The application (client) id of the Azure app registration used to log in with a device code
+	OutlookTenant          string `json:"outlook_tenant"`              // The Azure tenant. Default is "common"
+	GoogleBatchSize        int    `json:"google_batch_size"`           // Number of changes sent in a single google calendar batch request. Default and maximum is 50
+	FeedAddress            string `json:"feed_address"`                // The address the serve command listens on. Default is ":8080"
+	FeedRefreshInterval    int    `json:"feed_refresh_interval"`       // Number of minutes between two fetches of the planning by the serve command. Default is 60
+	FeedToken              string `json:"feed_token"`                  // The secret part of the feed urls. A random one is generated and saved in the feed_token file if empty
+	SyncInterval           int    `json:"sync_interval"`               // Number of minutes between two synchronisations in daemon mode. Default is 60
+	SyncPastDays           int    `json:"sync_past_days"`              // Number of past days synchronised. Default is 0: only the events that are not finished yet
+	SyncFutureDays         int    `json:"sync_future_days"`            // Number of days synchronised after today. Default is 60
+	SyncFrom               string `json:"sync_from"`                   // Absolute start date of the synchronisation (YYYY-MM-DD), overrides sync_past_days
+	SyncTo                 string `json:"sync_to"`                     // Absolute end date of the synchronisation (YYYY-MM-DD, inclusive), overrides sync_future_days
+	CredentialsFile        string `json:"credentials_file"`            // Path of the google client secret file. Default is credentials.json in the data directory
+	TokenFile              string `json:"token_file"`                  // Path of the file the oauth token is saved to. Default is token.json in the data directory
+	FetchErrorPolicy       string `json:"fetch_error_policy"`          // What to do when some modules can't be fetched: "skip_deletions" (default) keeps their project events, "fail" aborts the synchronisation
+	SecretStore            string `json:"secret_store"`                // Where the secrets and oauth tokens are stored: "keyring", "file" (encrypted with a passphrase) or "auto". Default is the config and token files
+	SecretFile             string `json:"secret_file"`                 // Path of the encrypted secret file. Default is secrets.enc in the data directory
+
+	// The profiles, each a config of its own
+	ProfileOverrides map[string]json.RawMessage `json:"profiles"` // Accounts synchronised together, by name. The fields of a profile override the ones of the config
+	Name             string                     `json:"-"`        // The name of the profile, empty for the config itself
+	profiles         []*Config                  // resolved from ProfileOverrides
+
+	secretSources     map[string]string // where the secrets not read from the config file come from, by field: "env" or "store"
+	overriddenSecrets map[string]string // the secrets of the config file replaced by the ones of the secret store, by field
 }
 
-// GetConfigInfos will create a config instance containing all the data from the config file
-func GetConfigInfos() (*Config, error) {
-	file, err := ioutil.ReadFile("config.json")
+// DefaultConfigFile is the name of the config file searched when no path is given, see FindConfig.
+// config.yaml, config.yml and config.toml are searched as well.
+const DefaultConfigFile = "config.json"
+
+// Defaults of the fields left out of the config file
+const (
+	DefaultCalendar      = "primary"
+	DefaultEventColor    = "3"
+	DefaultProjectColor  = "10"
+	DefaultLocationRegex = `\w{2}\/\w+\/\w+\/([\w-_]+)`
+)
+
+// DefaultSyncFutureDays is the number of days synchronised after today when sync_future_days is left out
+const DefaultSyncFutureDays = 60
+
+// DateFormat is the format of the sync_from and sync_to dates
+const DateFormat = "2006-01-02"
+
+// DefaultReminders are the reminders of the events when reminder_time is left out. An empty list disables them.
+var DefaultReminders = []int{10, 30}
+
+// GetConfigInfos will create a config instance containing all the data from the config file at path,
+// a JSON, YAML or TOML file according to its extension. The secrets are overridden by the environment variables,
+// then the fields left out get their default and the secrets still missing are read from the secret store.
+// The profiles are read as well, see Config.Profiles.
+// The config is not validated, see Config.Validate.
+func GetConfigInfos(path string) (*Config, error) {
+	file, err := ioutil.ReadFile(path)
 	var conf Config
 
 	if err != nil {
-		return nil, errors.New(("could not open file"))
+		return nil, fmt.Errorf("could not open file %s: %v", path, err)
 	}
-	err = json.Unmarshal([]byte(file), &conf)
+	data, err := toJSON(path, file)
 	if err != nil {
-		return nil, errors.New("could not unmarshall json, make sure the config file is in a correct format")
+		return nil, err
+	}
+	if err := decodeConfig(path, file, data, &conf); err != nil {
+		return nil, err
+	}
+	conf.ApplyEnvironment()
+	if err := conf.resolveProfiles(path); err != nil {
+		return nil, err
+	}
+	conf.applyDefaults()
+	if len(conf.profiles) == 0 {
+		if err := conf.loadSecrets(); err != nil {
+			return nil, err
+		}
 	}
 	return &conf, nil
 }
+
+// applyDefaults fills the fields left out of the config file with their documented default
+func (conf *Config) applyDefaults() {
+	if conf.Backend == "" || conf.Backend == "google" || conf.Backend == "outlook" { // caldav has no main calendar
+		if conf.GoogleCalendarEvents == "" {
+			conf.GoogleCalendarEvents = DefaultCalendar
+		}
+		if conf.GoogleCalendarProjects == "" {
+			conf.GoogleCalendarProjects = DefaultCalendar
+		}
+	}
+	if conf.GoogleCalendarProjects == "" {
+		conf.GoogleCalendarProjects = conf.GoogleCalendarEvents
+	}
+	if conf.LocationRegex == "" {
+		conf.LocationRegex = DefaultLocationRegex
+	}
+	if conf.Reminders == nil {
+		conf.Reminders = append([]int{}, DefaultReminders...)
+	}
+	if conf.SecretFile == "" { // shared by the profiles, their secrets are told apart by SecretKey
+		conf.SecretFile = DataFile("secrets.enc")
+	}
+	if conf.Backend == "" || conf.Backend == "google" { // the other backends name their colors
+		if conf.EventColor == "" {
+			conf.EventColor = DefaultEventColor
+		}
+		if conf.ProjectColor == "" {
+			conf.ProjectColor = DefaultProjectColor
+		}
+	}
+}
+
+// SyncWindow returns the period synchronised at now, in the location of now:
+// by default from now to the end of the day 60 days from now, so that today's events that are not finished yet are included.
+// sync_past_days and sync_future_days move the bounds relatively to today, sync_from and sync_to set absolute dates.
+// The window is empty when the start is not before the end, which Validate reports.
+func (conf *Config) SyncWindow(now time.Time) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := now
+	futureDays := conf.SyncFutureDays
+
+	if conf.SyncPastDays > 0 {
+		start = today.AddDate(0, 0, -conf.SyncPastDays)
+	}
+	if from, err := time.ParseInLocation(DateFormat, conf.SyncFrom, now.Location()); err == nil {
+		start = from
+	}
+	if futureDays <= 0 {
+		futureDays = DefaultSyncFutureDays
+	}
+	end := today.AddDate(0, 0, futureDays+1)
+	if to, err := time.ParseInLocation(DateFormat, conf.SyncTo, now.Location()); err == nil {
+		end = to.AddDate(0, 0, 1)
+	}
+	return start, end
+}
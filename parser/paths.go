@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AppName names the directories of the linker in the XDG config and data directories
+const AppName = "calendar-linker"
+
+// ConfigEnv is the environment variable holding the path of the config file
+const ConfigEnv = "CALENDAR_LINKER_CONFIG"
+
+// EnvPrefix prefixes the environment variables overriding the secrets of the config file, see Config.ApplyEnvironment
+const EnvPrefix = "CALENDAR_LINKER_"
+
+// ConfigDir returns the directory of the linker in $XDG_CONFIG_HOME, ~/.config by default
+func ConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, AppName), nil
+}
+
+// DataDir returns the directory of the linker in $XDG_DATA_HOME, ~/.local/share by default
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, AppName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", AppName), nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// FindConfig returns the path of the config file: path if not empty, then $CALENDAR_LINKER_CONFIG,
+// then the config file in the config directory of the linker, then the one of the working directory.
+// In each directory, config.json is searched first, then config.yaml, config.yml and config.toml.
+func FindConfig(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path, nil
+	}
+	dirs := []string{}
+	if dir, err := ConfigDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, ".")
+	searched := []string{}
+	name := strings.TrimSuffix(DefaultConfigFile, filepath.Ext(DefaultConfigFile))
+	for _, dir := range dirs {
+		for _, ext := range ConfigExtensions {
+			searched = append(searched, filepath.Join(dir, name+ext))
+		}
+	}
+	for _, candidate := range searched {
+		if exists(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no config file found, give its path with -config or " + ConfigEnv + ", or create one of " +
+		strings.Join(searched, ", "))
+}
+
+// DataFile returns the path of a file the linker stores data in, such as the oauth token: the file of the
+// working directory when it exists, as older versions stored them there, the file in the data directory otherwise.
+func DataFile(name string) string {
+	if exists(name) {
+		return name
+	}
+	dir, err := DataDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// ApplyEnvironment overrides the secrets of the config with the environment variables that are set, in order to
+// keep them out of the config file: CALENDAR_LINKER_EPITECH_AUTH overrides epitech_auth for example.
+func (conf *Config) ApplyEnvironment() {
+	conf.applyEnvironment(EnvPrefix)
+}
+
+// applyEnvironment overrides the secrets with the environment variables starting with prefix
+func (conf *Config) applyEnvironment(prefix string) {
+	for name, field := range conf.secretFields() {
+		if value, ok := os.LookupEnv(prefix + strings.ToUpper(name)); ok {
+			*field = value
+			conf.setSecretSource(name, "env")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// profileNamePattern matches the names of the profiles, also used as the name of their data directory
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// resolveProfiles builds the config of every profile of the profiles field: the fields of the profile
+// override the ones of the config. The defaults and the environment are applied to each profile.
+func (conf *Config) resolveProfiles(path string) error {
+	names := make([]string, 0, len(conf.ProfileOverrides))
+	for name := range conf.ProfileOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := conf.copy()
+		profile.Name = name
+		profile.ProfileOverrides = nil
+		source := fmt.Sprintf("%s (profile %s)", path, name)
+		if err := decodeConfig(source, nil, conf.ProfileOverrides[name], profile); err != nil {
+			return err
+		}
+		if profile.ProfileOverrides != nil {
+			return fmt.Errorf("could not read %s: a profile can't have profiles", source)
+		}
+		profile.resetSecretSources(conf.ProfileOverrides[name])
+		profile.applyEnvironment(EnvPrefix + profileEnvName(name) + "_")
+		profile.applyDefaults()
+		if err := profile.loadSecrets(); err != nil {
+			return err
+		}
+		conf.profiles = append(conf.profiles, profile)
+	}
+	return nil
+}
+
+// copy returns a copy of the config sharing none of its lists
+func (conf *Config) copy() *Config {
+	copied := *conf
+	copied.Semesters = append([]int(nil), conf.Semesters...)
+	if conf.Reminders != nil {
+		copied.Reminders = append([]int{}, conf.Reminders...)
+	}
+	copied.profiles = nil
+	copied.overriddenSecrets = nil
+	copied.secretSources = map[string]string{}
+	for field, source := range conf.secretSources {
+		copied.secretSources[field] = source
+	}
+	return &copied
+}
+
+// resetSecretSources forgets where the secrets set by the fields of the profile came from: a secret of the profile
+// comes from its config, even when the environment set the one of the root config
+func (conf *Config) resetSecretSources(override json.RawMessage) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(override, &fields); err != nil {
+		return
+	}
+	for name := range conf.secretFields() {
+		if _, ok := fields[name]; ok {
+			delete(conf.secretSources, name)
+		}
+	}
+}
+
+// profileEnvName returns the name of the profile as used in the environment variables: ALICE for alice
+func profileEnvName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Profiles returns the config of every profile sorted by name, or the config itself when it has no profile
+func (conf *Config) Profiles() []*Config {
+	if len(conf.profiles) == 0 {
+		return []*Config{conf}
+	}
+	return conf.profiles
+}
+
+// Profile returns the config of the profile called name
+func (conf *Config) Profile(name string) (*Config, error) {
+	for _, profile := range conf.profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	names := []string{}
+	for _, profile := range conf.profiles {
+		names = append(names, profile.Name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("unknown profile %q, the config has no profiles", name)
+	}
+	return nil, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// DataFile returns the path of a data file of the config, see DataFile. The files of a profile are stored
+// in a directory named after it, so that the profiles don't share their tokens.
+func (conf *Config) DataFile(name string) string {
+	if conf.Name == "" {
+		return DataFile(name)
+	}
+	return DataFile(filepath.Join(conf.Name, name))
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfileResolution(t *testing.T) {
+	defer withEnv(map[string]string{
+		EnvPrefix + "FEED_TOKEN":                "root-feed",
+		EnvPrefix + "ALICE_EPITECH_AUTH":        "https://intra.epitech.eu/auth-alice",
+		EnvPrefix + "BOB_SMITH_FEED_TOKEN":      "bob-feed",
+		EnvPrefix + "BOB_SMITH_CALDAV_PASSWORD": "bob-password",
+	})()
+	path := writeConfig(t, "config.json", `{
+		"epitech_auth": "https://intra.epitech.eu/auth-root",
+		"epitech_location_code": "FR/TLS",
+		"create_project_event": true,
+		"epitech_semesters": [1],
+		"reminder_time": [10],
+		"event_color": "5",
+		"profiles": {
+			"alice": {"epitech_location_code": "FR/PAR", "reminder_time": []},
+			"bob-smith": {"epitech_semesters": [3, 4], "calendar_backend": "caldav", "google_calendar_events": "epitech"}
+		}
+	}`)
+
+	conf, err := GetConfigInfos(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := conf.Profiles()
+	if len(profiles) != 2 || profiles[0].Name != "alice" || profiles[1].Name != "bob-smith" {
+		t.Fatalf("got the profiles %+v, want alice and bob-smith", profiles)
+	}
+	alice, bob := profiles[0], profiles[1]
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"the location of alice", alice.Location, "FR/PAR"},
+		{"the semesters of alice, from the root", alice.Semesters, []int{1}},
+		{"the reminders alice disabled", alice.Reminders, []int{}},
+		{"the color of alice, from the root", alice.EventColor, "5"},
+		{"the autologin of alice, from her environment", alice.EpitechAuth, "https://intra.epitech.eu/auth-alice"},
+		{"the feed token of alice, from the root environment", alice.FeedToken, "root-feed"},
+		{"the location of bob, from the root", bob.Location, "FR/TLS"},
+		{"the semesters of bob", bob.Semesters, []int{3, 4}},
+		{"the reminders of bob, from the root", bob.Reminders, []int{10}},
+		{"the autologin of bob, from the root", bob.EpitechAuth, "https://intra.epitech.eu/auth-root"},
+		{"the feed token of bob, from his environment", bob.FeedToken, "bob-feed"},
+		{"the caldav password of bob, from his environment", bob.CalDAVPassword, "bob-password"},
+		{"the projects calendar of bob, defaulted for caldav", bob.GoogleCalendarProjects, "epitech"},
+		{"the projects calendar of alice, defaulted for google", alice.GoogleCalendarProjects, DefaultCalendar},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("got %v as %s, want %v", test.got, test.name, test.want)
+		}
+	}
+
+	alice.Semesters[0] = 2
+	if conf.Semesters[0] != 1 || bob.Semesters[0] != 3 {
+		t.Errorf("the profiles share their lists: the root has the semesters %v and bob %v", conf.Semesters, bob.Semesters)
+	}
+	if got, err := conf.Profile("carol"); err == nil {
+		t.Errorf("got the profile %+v for an unknown name", got)
+	}
+}
+
+func TestProfileDataFiles(t *testing.T) {
+	dataDir := t.TempDir()
+	defer withEnv(map[string]string{"XDG_DATA_HOME": dataDir})()
+
+	root := &Config{}
+	alice := &Config{Name: "alice"}
+	if got, want := root.DataFile("token.json"), filepath.Join(dataDir, AppName, "token.json"); got != want {
+		t.Errorf("got the token %s, want %s", got, want)
+	}
+	if got, want := alice.DataFile("token.json"), filepath.Join(dataDir, AppName, "alice", "token.json"); got != want {
+		t.Errorf("got the token %s of alice, want %s", got, want)
+	}
+	if root.SecretKey("epitech_auth") != "epitech_auth" || alice.SecretKey("epitech_auth") != "alice/epitech_auth" {
+		t.Errorf("got the secret keys %s and %s", root.SecretKey("epitech_auth"), alice.SecretKey("epitech_auth"))
+	}
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nheuillet/calendar-linker/secrets"
+)
+
+// secretFields returns the secrets of the config by field name
+func (conf *Config) secretFields() map[string]*string {
+	return map[string]*string{
+		"epitech_auth":      &conf.EpitechAuth,
+		"caldav_username":   &conf.CalDAVUsername,
+		"caldav_password":   &conf.CalDAVPassword,
+		"outlook_client_id": &conf.OutlookClientID,
+		"feed_token":        &conf.FeedToken,
+	}
+}
+
+func (conf *Config) setSecretSource(field string, source string) {
+	if conf.secretSources == nil {
+		conf.secretSources = map[string]string{}
+	}
+	conf.secretSources[field] = source
+}
+
+// OpenSecretStore opens the secret store of the config, nil when secret_store is not set
+func (conf *Config) OpenSecretStore() (secrets.Store, error) {
+	if conf.SecretStore == "" {
+		return nil, nil
+	}
+	return secrets.Open(conf.SecretStore, conf.SecretFile)
+}
+
+// SecretKey returns the key a secret of the config is stored under: the name of the secret,
+// prefixed by the name of the profile, as the profiles share the store
+func (conf *Config) SecretKey(name string) string {
+	if conf.Name == "" {
+		return name
+	}
+	return conf.Name + "/" + name
+}
+
+// loadSecrets reads the secrets that are not set by the environment from the secret store.
+// Once migrate-secrets stored them, the secrets of the store take precedence over the ones of the config file.
+func (conf *Config) loadSecrets() error {
+	switch conf.SecretStore {
+	case secrets.KindKeyring, secrets.KindFile, secrets.KindAuto:
+	default: // no store, or an unknown one reported by Validate
+		return nil
+	}
+	store, err := conf.OpenSecretStore()
+	if err != nil {
+		return fmt.Errorf("could not open the secret store: %v", err)
+	}
+	for name, field := range conf.secretFields() {
+		if conf.secretSources[name] == "env" {
+			continue
+		}
+		value, err := store.Get(conf.SecretKey(name))
+		if err == secrets.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %s from %s: %v", name, store, err)
+		}
+		if *field != "" {
+			if conf.overriddenSecrets == nil {
+				conf.overriddenSecrets = map[string]string{}
+			}
+			conf.overriddenSecrets[name] = *field
+		}
+		*field = value
+		conf.setSecretSource(name, "store")
+	}
+	return nil
+}
+
+// FileSecrets returns the secrets of the config read from the config file, by field name,
+// including the ones the secret store overrides, but not the ones overridden by the environment
+func (conf *Config) FileSecrets() map[string]string {
+	fileSecrets := map[string]string{}
+	for name, field := range conf.secretFields() {
+		if *field != "" && conf.secretSources[name] == "" {
+			fileSecrets[name] = *field
+		}
+	}
+	for name, value := range conf.overriddenSecrets {
+		fileSecrets[name] = value
+	}
+	return fileSecrets
+}
+
+// OverriddenSecrets returns the sorted fields of the config file ignored because the secret store holds them,
+// which are left to remove from the config file after migrate-secrets
+func (conf *Config) OverriddenSecrets() []string {
+	fields := []string{}
+	for name := range conf.overriddenSecrets {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
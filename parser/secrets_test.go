@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nheuillet/calendar-linker/secrets"
+)
+
+// withEnv sets the environment variables until the returned function is called
+func withEnv(values map[string]string) func() {
+	previous := map[string]*string{}
+	for name, value := range values {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+// writeConfig writes a config file named name in a temporary directory, and returns its path
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newSecretStore creates an encrypted secret file holding values, and returns its path
+func newSecretStore(t *testing.T, values map[string]string) string {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	store, err := secrets.Open(secrets.KindFile, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		if err := store.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestSecretsPrecedence(t *testing.T) {
+	defer withEnv(map[string]string{secrets.PassphraseEnv: "correct horse", EnvPrefix + "EPITECH_AUTH": "env-auth"})()
+	store := newSecretStore(t, map[string]string{
+		"epitech_auth":      "store-auth",
+		"caldav_username":   "store-user",
+		"outlook_client_id": "store-client",
+	})
+	path := writeConfig(t, "config.json", `{
+		"epitech_auth": "file-auth",
+		"caldav_username": "file-user",
+		"caldav_password": "file-password",
+		"secret_store": "file",
+		"secret_file": "`+store+`"
+	}`)
+
+	conf, err := GetConfigInfos(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for name, field := range conf.secretFields() {
+		got[name] = *field
+	}
+	want := map[string]string{
+		"epitech_auth":      "env-auth",      // the environment first
+		"caldav_username":   "store-user",    // then the store
+		"caldav_password":   "file-password", // then the config file
+		"outlook_client_id": "store-client",
+		"feed_token":        "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got the secrets %v, want %v", got, want)
+	}
+	// the warning of the ignored secrets names the ones the store overrides, not the ones the environment overrides
+	if overridden := conf.OverriddenSecrets(); !reflect.DeepEqual(overridden, []string{"caldav_username"}) {
+		t.Errorf("got the ignored secrets %v, want caldav_username", overridden)
+	}
+	fileSecrets := map[string]string{"caldav_username": "file-user", "caldav_password": "file-password"}
+	if got := conf.FileSecrets(); !reflect.DeepEqual(got, fileSecrets) {
+		t.Errorf("got the secrets of the config file %v, want %v", got, fileSecrets)
+	}
+}
+
+func TestProfileSecretsFromTheirOwnStoreEntry(t *testing.T) {
+	defer withEnv(map[string]string{secrets.PassphraseEnv: "correct horse", EnvPrefix + "EPITECH_AUTH": "env-auth"})()
+	store := newSecretStore(t, map[string]string{
+		"alice/epitech_auth": "alice-store",
+		"bob/epitech_auth":   "bob-store",
+	})
+	path := writeConfig(t, "config.json", `{
+		"secret_store": "file",
+		"secret_file": "`+store+`",
+		"profiles": {
+			"alice": {"epitech_auth": "alice-file"},
+			"bob": {"location_regex": "(\\w+)"}
+		}
+	}`)
+
+	conf, err := GetConfigInfos(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := conf.Profile("alice")
+	bob, _ := conf.Profile("bob")
+	// alice sets the field, so the store holds her secret; bob inherits the one of the root, set by the environment
+	if alice.EpitechAuth != "alice-store" || bob.EpitechAuth != "env-auth" {
+		t.Errorf("alice got %q and bob %q, want alice-store and env-auth", alice.EpitechAuth, bob.EpitechAuth)
+	}
+	if overridden := alice.OverriddenSecrets(); !reflect.DeepEqual(overridden, []string{"epitech_auth"}) {
+		t.Errorf("got the ignored secrets %v of alice, want epitech_auth", overridden)
+	}
+}
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/secrets"
+)
+
+// Policies applied when some modules or project groups can't be fetched from the intra
+const (
+	PolicySkipDeletions = "skip_deletions" // synchronise, keeping the project events of the failed modules
+	PolicyFail          = "fail"           // abort the synchronisation
+)
+
+// Limits of the google calendar API
+const (
+	maxColorID      = 11    // event colors go from "1" to "11"
+	maxReminders    = 5     // reminders of an event
+	maxReminderTime = 40320 // minutes, 4 weeks
+	maxBatchSize    = 50    // requests of a batch
+)
+
+// maxSemester is the last semester of the Epitech curriculum, semester 0 holds the modules of every semester
+const maxSemester = 10
+
+// autologinPattern matches the autologin token, alone or at the end of the autologin link
+var autologinPattern = regexp.MustCompile(`^(.*/)?auth-[0-9a-zA-Z]+/?$`)
+
+// locationPattern matches the location codes of the intra, such as FR/TLS
+var locationPattern = regexp.MustCompile(`^[A-Z]{2}(/[A-Z]+)*$`)
+
+// FieldError is a problem of a field of the config
+type FieldError struct {
+	Field   string // the name of the field in the config file
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists every problem found in the config
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	problems := make([]string, len(e))
+	for i, problem := range e {
+		problems[i] = problem.Error()
+	}
+	return strings.Join(problems, "\n  ")
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks every field of the config and returns a ValidationError listing all their problems, nil if there is none.
+// When the config has profiles, each of them is checked instead, their problems are reported as profiles.<name>.<field>.
+func (conf *Config) Validate() error {
+	problems := ValidationError{}
+
+	if len(conf.profiles) == 0 {
+		conf.validateFields(&problems)
+	}
+	for _, profile := range conf.profiles {
+		if !profileNamePattern.MatchString(profile.Name) {
+			problems.add("profiles", "%q is not a valid profile name, use letters, digits, - and _", profile.Name)
+		}
+		profileProblems := ValidationError{}
+		profile.validateFields(&profileProblems)
+		for _, problem := range profileProblems {
+			problems.add("profiles."+profile.Name+"."+problem.Field, "%s", problem.Message)
+		}
+	}
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
+
+func (conf *Config) validateFields(problems *ValidationError) {
+	conf.validateIntra(problems)
+	conf.validateCalendars(problems)
+	conf.validateEvents(problems)
+	conf.validateSync(problems)
+}
+
+func (conf *Config) validateIntra(problems *ValidationError) {
+	if conf.EpitechAuth == "" {
+		problems.add("epitech_auth", "missing, copy the autologin link from https://intra.epitech.eu/admin/autolog")
+	} else if !autologinPattern.MatchString(conf.EpitechAuth) {
+		problems.add("epitech_auth", "expected the autologin link or its auth-... token, copy it from https://intra.epitech.eu/admin/autolog")
+	}
+	if conf.IntraURL != "" {
+		if u, err := url.Parse(conf.IntraURL); err != nil || u.Scheme == "" || u.Host == "" {
+			problems.add("intra_url", "%q is not an absolute url, such as https://intra.epitech.eu", conf.IntraURL)
+		}
+	}
+	if conf.Location == "" {
+		problems.add("epitech_location_code", "missing, such as FR/TLS for Toulouse")
+	} else if !locationPattern.MatchString(conf.Location) {
+		problems.add("epitech_location_code", "%q is not a location code, such as FR/TLS for Toulouse", conf.Location)
+	}
+	if reg, err := regexp.Compile(conf.LocationRegex); err != nil {
+		problems.add("location_regex", "%v", err)
+	} else if reg.NumSubexp() != 1 {
+		problems.add("location_regex", "expected exactly one capture group around the room name, found %d", reg.NumSubexp())
+	}
+	if conf.Timezone != "" {
+		if _, err := time.LoadLocation(conf.Timezone); err != nil {
+			problems.add("timezone", "%v, expected a name such as Europe/Paris", err)
+		}
+	}
+	if conf.ProjectEvent && len(conf.Semesters) == 0 {
+		problems.add("epitech_semesters", "missing, create_project_event needs the semesters to scan")
+	}
+	seen := map[int]bool{}
+	for _, semester := range conf.Semesters {
+		if semester < 0 || semester > maxSemester {
+			problems.add("epitech_semesters", "%d is not a semester, expected 0 to %d", semester, maxSemester)
+		} else if seen[semester] {
+			problems.add("epitech_semesters", "semester %d is listed twice", semester)
+		}
+		seen[semester] = true
+	}
+}
+
+func (conf *Config) validateCalendars(problems *ValidationError) {
+	switch conf.Backend {
+	case "", "google":
+	case "caldav":
+		if conf.GoogleCalendarEvents == "" { // the projects calendar defaults to this one
+			problems.add("google_calendar_events", "missing, the caldav backend needs the name or the path of a calendar")
+		}
+		if u, err := url.Parse(conf.CalDAVURL); conf.CalDAVURL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+			problems.add("caldav_url", "expected the absolute url of the CalDAV server with the caldav backend")
+		}
+		if conf.CalDAVUsername == "" {
+			problems.add("caldav_username", "missing, the caldav backend needs a user")
+		}
+	case "outlook":
+		if conf.OutlookClientID == "" {
+			problems.add("outlook_client_id", "missing, the outlook backend needs the client id of an Azure app registration")
+		}
+	default:
+		problems.add("calendar_backend", "unknown backend %q, expected google, caldav or outlook", conf.Backend)
+	}
+	if conf.GoogleBatchSize < 0 || conf.GoogleBatchSize > maxBatchSize {
+		problems.add("google_batch_size", "%d is out of range, expected 1 to %d, or 0 for the default", conf.GoogleBatchSize, maxBatchSize)
+	}
+}
+
+func (conf *Config) validateEvents(problems *ValidationError) {
+	google := conf.Backend == "" || conf.Backend == "google"
+	colors := []struct {
+		field string
+		value string
+	}{{"event_color", conf.EventColor}, {"project_color", conf.ProjectColor}}
+	for _, color := range colors {
+		if !google || color.value == "" { // other backends use names, such as outlook categories
+			continue
+		}
+		if id, err := strconv.Atoi(color.value); err != nil || id < 1 || id > maxColorID {
+			problems.add(color.field, "%q is not a google calendar color, expected \"1\" to \"%d\"", color.value, maxColorID)
+		}
+	}
+	if google && len(conf.Reminders) > maxReminders {
+		problems.add("reminder_time", "%d reminders, google calendar allows at most %d", len(conf.Reminders), maxReminders)
+	}
+	for _, reminder := range conf.Reminders {
+		if reminder < 0 || reminder > maxReminderTime {
+			problems.add("reminder_time", "%d minutes is out of range, expected 0 to %d (4 weeks)", reminder, maxReminderTime)
+		}
+	}
+}
+
+func (conf *Config) validateSync(problems *ValidationError) {
+	if conf.SyncInterval < 0 {
+		problems.add("sync_interval", "%d minutes is negative", conf.SyncInterval)
+	}
+	if conf.FeedRefreshInterval < 0 {
+		problems.add("feed_refresh_interval", "%d minutes is negative", conf.FeedRefreshInterval)
+	}
+	if conf.SyncPastDays < 0 {
+		problems.add("sync_past_days", "%d days is negative", conf.SyncPastDays)
+	}
+	if conf.SyncFutureDays < 0 {
+		problems.add("sync_future_days", "%d days is negative", conf.SyncFutureDays)
+	}
+	count := len(*problems)
+	from, fromErr := time.Parse(DateFormat, conf.SyncFrom)
+	if conf.SyncFrom != "" && fromErr != nil {
+		problems.add("sync_from", "%q is not a YYYY-MM-DD date", conf.SyncFrom)
+	}
+	to, toErr := time.Parse(DateFormat, conf.SyncTo)
+	if conf.SyncTo != "" && toErr != nil {
+		problems.add("sync_to", "%q is not a YYYY-MM-DD date", conf.SyncTo)
+	}
+	if fromErr == nil && toErr == nil && to.Before(from) {
+		problems.add("sync_to", "%s is before sync_from %s", conf.SyncTo, conf.SyncFrom)
+	}
+	if len(*problems) == count { // the window of a day in the timezone of the campus, the local one is close enough
+		if start, end := conf.SyncWindow(time.Now()); !start.Before(end) {
+			problems.add("sync_from", "the start of the synchronisation is after its end")
+		}
+	}
+	switch conf.SecretStore {
+	case "", secrets.KindKeyring, secrets.KindFile, secrets.KindAuto:
+	default:
+		problems.add("secret_store", "unknown store %q, expected %s, %s or %s", conf.SecretStore, secrets.KindKeyring, secrets.KindFile, secrets.KindAuto)
+	}
+	switch conf.FetchErrorPolicy {
+	case "", PolicySkipDeletions, PolicyFail:
+	default:
+		problems.add("fetch_error_policy", "unknown policy %q, expected %s or %s", conf.FetchErrorPolicy, PolicySkipDeletions, PolicyFail)
+	}
+}
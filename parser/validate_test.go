@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+// validConfig returns a config Validate accepts, with its defaults
+func validConfig() *Config {
+	conf := &Config{
+		EpitechAuth:  "https://intra.epitech.eu/auth-0123abcd",
+		Location:     "FR/TLS",
+		ProjectEvent: true,
+		Semesters:    []int{0, 1, 2},
+	}
+	conf.applyDefaults()
+	return conf
+}
+
+// problemFields returns the fields of the problems reported by Validate
+func problemFields(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+	problems, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	fields := []string{}
+	for _, problem := range problems {
+		fields = append(fields, problem.Field)
+	}
+	return fields
+}
+
+func TestValidateFields(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("the valid config is rejected: %v", err)
+	}
+
+	tests := []struct {
+		field  string
+		change func(conf *Config)
+	}{
+		{"epitech_auth", func(conf *Config) { conf.EpitechAuth = "" }},
+		{"epitech_auth", func(conf *Config) { conf.EpitechAuth = "https://intra.epitech.eu/" }},
+		{"intra_url", func(conf *Config) { conf.IntraURL = "intra.epitech.eu" }},
+		{"epitech_location_code", func(conf *Config) { conf.Location = "" }},
+		{"epitech_location_code", func(conf *Config) { conf.Location = "fr/tls" }},
+		{"location_regex", func(conf *Config) { conf.LocationRegex = `([` }},
+		{"location_regex", func(conf *Config) { conf.LocationRegex = `\w+` }},
+		{"timezone", func(conf *Config) { conf.Timezone = "Europe/Toulouse" }},
+		{"epitech_semesters", func(conf *Config) { conf.Semesters = nil }},
+		{"epitech_semesters", func(conf *Config) { conf.Semesters = []int{11} }},
+		{"epitech_semesters", func(conf *Config) { conf.Semesters = []int{1, 1} }},
+		{"calendar_backend", func(conf *Config) { conf.Backend = "icloud" }},
+		{"google_calendar_events", func(conf *Config) {
+			conf.Backend, conf.GoogleCalendarEvents, conf.CalDAVURL, conf.CalDAVUsername = "caldav", "", "https://dav.example.com", "alice"
+		}},
+		{"caldav_url", func(conf *Config) {
+			conf.Backend, conf.CalDAVURL, conf.CalDAVUsername = "caldav", "dav.example.com", "alice"
+		}},
+		{"caldav_username", func(conf *Config) { conf.Backend, conf.CalDAVURL = "caldav", "https://dav.example.com" }},
+		{"outlook_client_id", func(conf *Config) { conf.Backend = "outlook" }},
+		{"google_batch_size", func(conf *Config) { conf.GoogleBatchSize = 51 }},
+		{"event_color", func(conf *Config) { conf.EventColor = "12" }},
+		{"project_color", func(conf *Config) { conf.ProjectColor = "red" }},
+		{"reminder_time", func(conf *Config) { conf.Reminders = []int{1, 2, 3, 4, 5, 6} }},
+		{"reminder_time", func(conf *Config) { conf.Reminders = []int{40321} }},
+		{"sync_interval", func(conf *Config) { conf.SyncInterval = -1 }},
+		{"feed_refresh_interval", func(conf *Config) { conf.FeedRefreshInterval = -1 }},
+		{"sync_past_days", func(conf *Config) { conf.SyncPastDays = -1 }},
+		{"sync_future_days", func(conf *Config) { conf.SyncFutureDays = -1 }},
+		{"sync_from", func(conf *Config) { conf.SyncFrom = "01/09/2026" }},
+		{"sync_from", func(conf *Config) { conf.SyncTo = "2020-01-01" }}, // the window ends before now
+		{"sync_to", func(conf *Config) { conf.SyncTo = "2026-13-01" }},
+		{"sync_to", func(conf *Config) { conf.SyncFrom, conf.SyncTo = "2026-09-02", "2026-09-01" }},
+		{"secret_store", func(conf *Config) { conf.SecretStore = "vault" }},
+		{"fetch_error_policy", func(conf *Config) { conf.FetchErrorPolicy = "retry" }},
+	}
+	for i, test := range tests {
+		conf := validConfig()
+		test.change(conf)
+		if fields := problemFields(t, conf.Validate()); !reflect.DeepEqual(fields, []string{test.field}) {
+			t.Errorf("case %d: got problems with %v, want %s only", i, fields, test.field)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	conf := validConfig()
+	conf.EpitechAuth = ""
+	conf.Location = "Toulouse"
+	conf.Semesters = []int{1, 1, 12}
+	conf.Backend = "caldav"
+	conf.EventColor = "red" // a name, allowed with caldav
+	conf.Reminders = []int{-5}
+	conf.SyncFrom = "tomorrow"
+	conf.FetchErrorPolicy = "retry"
+
+	want := []string{
+		"epitech_auth",
+		"epitech_location_code",
+		"epitech_semesters", "epitech_semesters",
+		"caldav_url", "caldav_username",
+		"reminder_time",
+		"sync_from",
+		"fetch_error_policy",
+	}
+	if fields := problemFields(t, conf.Validate()); !reflect.DeepEqual(fields, want) {
+		t.Errorf("got problems with %v, want %v", fields, want)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	alice := validConfig()
+	alice.Name = "alice"
+	bob := validConfig()
+	bob.Name = "bob smith"
+	bob.SyncInterval = -1
+	conf := &Config{profiles: []*Config{alice, bob}} // the root itself is not checked
+
+	want := []string{"profiles", "profiles.bob smith.sync_interval"}
+	if fields := problemFields(t, conf.Validate()); !reflect.DeepEqual(fields, want) {
+		t.Errorf("got problems with %v, want %v", fields, want)
+	}
+}
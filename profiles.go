@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/nheuillet/calendar-linker/agenda"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// profileRun is the synchronisation of a profile
+type profileRun struct {
+	config  *parser.Config
+	backend agenda.CalendarBackend
+	changes []agenda.Change // the changes of a dry-run
+	err     error
+}
+
+// newProfileRuns creates the calendar backend of every profile. They are created one after the other, as the
+// profiles that never logged in are asked to on the terminal. A profile whose backend fails keeps its error.
+func newProfileRuns(ctx context.Context, profiles []*parser.Config) []*profileRun {
+	runs := make([]*profileRun, len(profiles))
+	for i, profile := range profiles {
+		runs[i] = &profileRun{config: profile}
+		runs[i].backend, runs[i].err = agenda.NewBackend(profileContext(ctx, profile), profile)
+	}
+	return runs
+}
+
+// syncProfile fetches the planning of the profile and synchronises its calendars, or only plans the changes on a dry-run
+func syncProfile(ctx context.Context, run *profileRun, dryRun bool) error {
+	ctx = profileContext(ctx, run.config)
+	registeredEvents, projects, fetchErrs, err := fetchPlanning(ctx, run.config)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		run.changes, err = agenda.PlanEvents(ctx, run.backend, run.config, registeredEvents, projects, fetchErrs)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = agenda.CreateEvents(ctx, run.backend, run.config, registeredEvents, projects, fetchErrs)
+	}
+	logRunReport(ctx, registeredEvents, projects, fetchErrs)
+	return err
+}
+
+// syncProfiles synchronises the profiles at the same time. A profile failing does not stop the other ones:
+// every failure is reported at the end, and an error is returned if any profile failed.
+func syncProfiles(ctx context.Context, profiles []*parser.Config, dryRun bool, jsonOutput bool) error {
+	runs := newProfileRuns(ctx, profiles)
+	wg := sync.WaitGroup{}
+	for _, run := range runs {
+		if run.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(run *profileRun) {
+			defer wg.Done()
+			run.err = syncProfile(ctx, run, dryRun)
+		}(run)
+	}
+	wg.Wait()
+
+	if len(runs) == 1 {
+		if runs[0].err == nil && dryRun {
+			return printPlan(runs[0].changes, jsonOutput)
+		}
+		return runs[0].err
+	}
+	if dryRun {
+		if err := printProfilePlans(runs, jsonOutput); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	for _, run := range runs {
+		if run.err != nil {
+			failed++
+			log.Printf("Profile %s: failed: %v\n", run.config.Name, run.err)
+		} else {
+			log.Printf("Profile %s: synchronised\n", run.config.Name)
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d profiles failed", failed, len(runs))
+	}
+	return nil
+}
+
+// printProfilePlans prints the changes of the dry-run of every profile that succeeded, as tables under the name
+// of their profile or as a JSON object of the changes by profile
+func printProfilePlans(runs []*profileRun, jsonOutput bool) error {
+	if jsonOutput {
+		plans := map[string][]agenda.Change{}
+		for _, run := range runs {
+			if run.err == nil {
+				plans[run.config.Name] = run.changes
+			}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plans)
+	}
+	for _, run := range runs {
+		if run.err != nil {
+			continue
+		}
+		fmt.Printf("Profile %s:\n", run.config.Name)
+		if err := printPlan(run.changes, false); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
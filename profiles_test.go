@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+func TestProfileTokenFiles(t *testing.T) {
+	defer os.Setenv("XDG_DATA_HOME", os.Getenv("XDG_DATA_HOME"))
+	defer func(config, token, profile string) { *configPath, *tokenPath, *profileName = config, token, profile }(*configPath, *tokenPath, *profileName)
+	dir := t.TempDir()
+	os.Setenv("XDG_DATA_HOME", dir)
+	*configPath = filepath.Join(dir, "config.json")
+	err := ioutil.WriteFile(*configPath, []byte(`{
+		"epitech_auth": "https://intra.epitech.eu/auth-0123abcd",
+		"epitech_location_code": "FR/TLS",
+		"profiles": {
+			"alice": {"token_file": "/etc/linker/alice.json"},
+			"bob": {}
+		}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for _, profile := range profiles {
+		tokens[profile.Name] = profile.TokenFile
+	}
+	if want := filepath.Join(dir, parser.AppName, "bob", "token.json"); tokens["alice"] != "/etc/linker/alice.json" || tokens["bob"] != want {
+		t.Errorf("got the tokens %v, want /etc/linker/alice.json for alice and %s for bob", tokens, want)
+	}
+
+	*tokenPath = filepath.Join(dir, "token.json")
+	if _, err := loadProfiles(); err == nil {
+		t.Error("-token is shared by both profiles")
+	}
+	*profileName = "bob"
+	profiles, err = loadProfiles()
+	if err != nil || len(profiles) != 1 || profiles[0].TokenFile != *tokenPath {
+		t.Errorf("got %+v, %v, want bob with the token of -token", profiles, err)
+	}
+}
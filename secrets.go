@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nheuillet/calendar-linker/agenda"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+// migrateSecrets moves the secrets of the config file and the oauth token files of the profile to its secret store,
+// replacing the ones it already holds. It returns the fields to remove from the config file, which are ignored from now on.
+func migrateSecrets(profile *parser.Config) ([]string, error) {
+	store, err := profile.OpenSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, errors.New("no secret store, set secret_store to keyring, file or auto in the config file")
+	}
+	fields := []string{}
+	for field, value := range profile.FileSecrets() {
+		if err := store.Set(profile.SecretKey(field), value); err != nil {
+			return fields, fmt.Errorf("unable to store %s: %v", field, err)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Printf("Moved %s of %s to %s\n", field, profileLabel(profile), store)
+	}
+	files, err := agenda.MigrateTokens(profile)
+	for _, file := range files {
+		fmt.Printf("Moved %s of %s to %s\n", file, profileLabel(profile), store)
+	}
+	return fields, err
+}
+
+func runMigrateSecrets(ctx context.Context, args []string) error {
+	newFlagSet("migrate-secrets").Parse(args)
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	remove := map[string]bool{}
+	for _, profile := range profiles {
+		fields, err := migrateSecrets(profile)
+		for _, field := range fields {
+			remove[field] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) == 0 {
+		fmt.Println("No secret left to remove from the config file.")
+		return nil
+	}
+	fields := []string{}
+	for field := range remove {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	fmt.Printf("These fields of %s are now ignored, as they are read from the secret store. Remove them from the config file:\n", *configPath)
+	for _, field := range fields {
+		fmt.Println("  " + field)
+	}
+	return nil
+}
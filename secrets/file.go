@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters of the key derivation of the passphrase
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	keyLength = 32 // AES-256
+	saltSize  = 16
+)
+
+// encryptedFile is the content of the file: the secrets as JSON, encrypted with AES-GCM
+// by a key derived from the passphrase with scrypt
+type encryptedFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// fileStore saves the secrets in a file encrypted with a passphrase. The whole file is written on every change.
+type fileStore struct {
+	mu     sync.Mutex
+	path   string
+	salt   []byte
+	key    []byte
+	values map[string]string
+}
+
+// openFile decrypts the file at path, or prepares a new one if it does not exist yet
+func openFile(path string) (Store, error) {
+	store := &fileStore{path: path, values: map[string]string{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		passphrase, err := readPassphrase(path, true)
+		if err != nil {
+			return nil, err
+		}
+		store.salt = make([]byte, saltSize)
+		if _, err := rand.Read(store.salt); err != nil {
+			return nil, err
+		}
+		store.key, err = scrypt.Key([]byte(passphrase), store.salt, scryptN, scryptR, scryptP, keyLength)
+		return store, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := encryptedFile{}
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != 1 {
+		return nil, fmt.Errorf("%s is not a secret file of the linker", path)
+	}
+	passphrase, err := readPassphrase(path, false)
+	if err != nil {
+		return nil, err
+	}
+	store.salt = file.Salt
+	if store.key, err = scrypt.Key([]byte(passphrase), file.Salt, scryptN, scryptR, scryptP, keyLength); err != nil {
+		return nil, err
+	}
+	aead, err := store.aead()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s, wrong passphrase", path)
+	}
+	if err := json.Unmarshal(plain, &store.values); err != nil {
+		return nil, fmt.Errorf("%s is corrupted: %v", path, err)
+	}
+	return store, nil
+}
+
+func (s *fileStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// save encrypts the secrets with a new nonce and replaces the file
+func (s *fileStore) save() error {
+	plain, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(encryptedFile{Version: 1, Salt: s.salt, Nonce: nonce, Data: aead.Seal(nil, nonce, plain, nil)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *fileStore) Set(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		return errors.New("empty secret key")
+	}
+	s.values[key] = value
+	return s.save()
+}
+
+func (s *fileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[key]; !ok {
+		return nil
+	}
+	delete(s.values, key)
+	return s.save()
+}
+
+func (s *fileStore) String() string {
+	return s.path
+}
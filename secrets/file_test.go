@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withPassphrase sets the passphrase of the encrypted files until the returned function is called
+func withPassphrase(passphrase string) func() {
+	previous, ok := os.LookupEnv(PassphraseEnv)
+	os.Setenv(PassphraseEnv, passphrase)
+	return func() {
+		if ok {
+			os.Setenv(PassphraseEnv, previous)
+		} else {
+			os.Unsetenv(PassphraseEnv)
+		}
+	}
+}
+
+// newSecretFile creates an encrypted file at path holding the autologin link
+func newSecretFile(t *testing.T, path string) {
+	store, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("epitech_auth", "https://intra.epitech.eu/auth-0123abcd"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	defer withPassphrase("correct horse")()
+	path := filepath.Join(t.TempDir(), "secrets", "secrets.enc")
+
+	store, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("epitech_auth"); err != ErrNotFound {
+		t.Fatalf("got %v from a new file, want ErrNotFound", err)
+	}
+	for key, value := range map[string]string{"epitech_auth": "https://intra.epitech.eu/auth-0123abcd", "alice/token.json": `{"access_token":"access"}`, "removed": "gone"} {
+		if err := store.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("removed"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "auth-0123abcd") || strings.Contains(string(data), "access_token") {
+		t.Fatalf("the secrets are saved in clear: %s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the file has the mode %v (%v), want 0600", info.Mode(), err)
+	}
+
+	reopened, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := reopened.Get("epitech_auth"); err != nil || value != "https://intra.epitech.eu/auth-0123abcd" {
+		t.Errorf("got %q, %v, want the autologin link", value, err)
+	}
+	if value, err := reopened.Get("alice/token.json"); err != nil || value != `{"access_token":"access"}` {
+		t.Errorf("got %q, %v, want the token", value, err)
+	}
+	if _, err := reopened.Get("removed"); err != ErrNotFound {
+		t.Errorf("got %v for a deleted secret, want ErrNotFound", err)
+	}
+}
+
+func TestFileWrongPassphrase(t *testing.T) {
+	restore := withPassphrase("correct horse")
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	newSecretFile(t, path)
+	restore()
+
+	defer withPassphrase("battery staple")()
+	if _, err := openFile(path); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("got %v, want a wrong passphrase error", err)
+	}
+}
+
+func TestFileTampered(t *testing.T) {
+	defer withPassphrase("correct horse")()
+
+	tests := []struct {
+		name   string
+		tamper func(file *encryptedFile)
+	}{
+		{"data", func(file *encryptedFile) { file.Data[0] ^= 1 }},
+		{"nonce", func(file *encryptedFile) { file.Nonce[0] ^= 1 }},
+		{"salt", func(file *encryptedFile) { file.Salt[0] ^= 1 }},
+		{"truncated data", func(file *encryptedFile) { file.Data = file.Data[:len(file.Data)-1] }},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "secrets.enc")
+		newSecretFile(t, path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := encryptedFile{}
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatal(err)
+		}
+		test.tamper(&file)
+		data, _ = json.Marshal(file)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openFile(path); err == nil {
+			t.Errorf("%s: the tampered file was opened", test.name)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	if err := ioutil.WriteFile(path, []byte(`{"epitech_auth":"in clear"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openFile(path); err == nil || !strings.Contains(err.Error(), "not a secret file") {
+		t.Errorf("got %v, want the file to be rejected", err)
+	}
+}
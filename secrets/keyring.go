@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service the secrets are saved under in the keyring
+const keyringService = "calendar-linker"
+
+// keyringStore saves the secrets in the keyring of the OS
+type keyringStore struct{}
+
+// openKeyring checks that the keyring can be reached, the Secret Service may not run on a server
+func openKeyring() (Store, error) {
+	if _, err := keyring.Get(keyringService, "probe"); err != nil && err != keyring.ErrNotFound {
+		return nil, fmt.Errorf("keyring unavailable: %v", err)
+	}
+	return keyringStore{}, nil
+}
+
+func (keyringStore) Get(key string) (string, error) {
+	value, err := keyring.Get(keyringService, key)
+	if err == keyring.ErrNotFound {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (keyringStore) Set(key string, value string) error {
+	return keyring.Set(keyringService, key, value)
+}
+
+func (keyringStore) Delete(key string) error {
+	err := keyring.Delete(keyringService, key)
+	if err == keyring.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (keyringStore) String() string {
+	return "the keyring"
+}
@@ -0,0 +1,108 @@
+// Package secrets stores the secrets of the linker, such as the autologin link and the oauth tokens,
+// out of the config and token files: in the keyring of the OS, or in a file encrypted with a passphrase.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Kinds of store
+const (
+	KindKeyring = "keyring" // the keyring of the OS: the Secret Service over D-Bus on Linux, the Keychain on macOS
+	KindFile    = "file"    // a file encrypted with a passphrase
+	KindAuto    = "auto"    // the keyring when available, the encrypted file otherwise
+)
+
+// PassphraseEnv is the environment variable holding the passphrase of the encrypted file, asked on the terminal if unset
+const PassphraseEnv = "CALENDAR_LINKER_PASSPHRASE"
+
+// ErrNotFound is returned when the store holds no secret with the key
+var ErrNotFound = errors.New("secret not found")
+
+// Store holds secrets by key
+type Store interface {
+	Get(key string) (string, error) // returns ErrNotFound when the key has no secret
+	Set(key string, value string) error
+	Delete(key string) error
+	String() string // describes the store in the messages
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]Store{}
+)
+
+// Open opens the store of the kind. path is the encrypted file used by the file kind, and by the auto kind when
+// the keyring is unavailable. A store is opened once, so that the passphrase is asked once.
+func Open(kind string, path string) (Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	if store, ok := stores[kind+"\x00"+path]; ok {
+		return store, nil
+	}
+	var store Store
+	var err error
+	switch kind {
+	case KindKeyring:
+		store, err = openKeyring()
+	case KindFile:
+		store, err = openFile(path)
+	case KindAuto:
+		if store, err = openKeyring(); err != nil {
+			store, err = openFile(path)
+		}
+	default:
+		err = fmt.Errorf("unknown secret store %q, expected %s, %s or %s", kind, KindKeyring, KindFile, KindAuto)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stores[kind+"\x00"+path] = store
+	return store, nil
+}
+
+// readPassphrase returns the passphrase of the environment, or asks it on the terminal.
+// A new passphrase is asked twice.
+func readPassphrase(path string, create bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no passphrase for %s, set %s", path, PassphraseEnv)
+	}
+	prompt := "Passphrase of " + path + ": "
+	if create {
+		prompt = "New passphrase of " + path + ": "
+	}
+	passphrase, err := askPassphrase(prompt)
+	if err != nil || !create {
+		return passphrase, err
+	}
+	confirmation, err := askPassphrase("Confirm the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirmation != passphrase {
+		return "", errors.New("the passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func askPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the passphrase: %v", err)
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	return string(passphrase), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nheuillet/calendar-linker/agenda"
+	"github.com/nheuillet/calendar-linker/intra"
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+const (
+	feedTokenFile          = "feed_token"
+	defaultFeedAddress     = ":8080"
+	defaultRefreshInterval = 60 // minutes
+)
+
+// feed is an iCalendar feed kept in memory between two refreshes of the planning
+type feed struct {
+	mu       sync.RWMutex
+	name     string
+	content  []byte
+	etag     string
+	modified time.Time
+}
+
+// update replaces the content of the feed if the data it was rendered from changed.
+// hash identifies the data, the content itself can't be compared as it holds the time it was rendered at.
+func (f *feed) update(content []byte, hash string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	etag := `"` + hash + `"`
+	if etag == f.etag {
+		return
+	}
+	f.content = content
+	f.etag = etag
+	f.modified = time.Now()
+}
+
+// ServeHTTP sends the feed. ETag/If-None-Match and Last-Modified/If-Modified-Since
+// are handled by http.ServeContent so that polling clients get a 304 when nothing changed.
+func (f *feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.RLock()
+	content, etag, modified := f.content, f.etag, f.modified
+	f.mu.RUnlock()
+
+	if content == nil {
+		http.Error(w, "planning not fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, f.name, modified, bytes.NewReader(content))
+}
+
+// getFeedToken returns the token protecting the feed urls: the feed_token field of the config file,
+// or a random token generated on the first run and saved in the feed_token file of the data directory.
+// An empty feed_token file is replaced by a new token, as it would leave the feeds unprotected.
+func getFeedToken(config *parser.Config) (string, error) {
+	if token := strings.TrimSpace(config.FeedToken); token != "" {
+		return token, nil
+	}
+	path := config.DataFile(feedTokenFile)
+	if token, err := ioutil.ReadFile(path); err == nil && strings.TrimSpace(string(token)) != "" {
+		return strings.TrimSpace(string(token)), nil
+	}
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return token, ioutil.WriteFile(path, []byte(token+"\n"), 0600)
+}
+
+// hashData identifies the data a feed is rendered from
+func hashData(data interface{}) string {
+	raw, _ := json.Marshal(data)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// refreshFeeds fetches the planning from the intra, within the -timeout duration, and renders the feeds
+func refreshFeeds(ctx context.Context, config *parser.Config, events *feed, projects *feed) error {
+	ctx, cancel := runContext(ctx)
+	defer cancel()
+	registeredEvents, projectList, fetchErrs, err := fetchPlanning(ctx, config)
+	if err != nil {
+		return err
+	}
+	for _, fetchErr := range fetchErrs {
+		log.Printf("Unable to fetch the %v\n", fetchErr)
+	}
+
+	var content bytes.Buffer
+	if err := agenda.WriteICS(&content, config, "Epitech events", *registeredEvents, nil); err != nil {
+		return err
+	}
+	events.update(append([]byte{}, content.Bytes()...), hashData(registeredEvents))
+
+	if projectList == nil {
+		projectList = &[]intra.Activity{}
+	}
+	content.Reset()
+	if err := agenda.WriteICS(&content, config, "Epitech projects", nil, *projectList); err != nil {
+		return err
+	}
+	projects.update(append([]byte{}, content.Bytes()...), hashData(projectList))
+	return nil
+}
+
+// serve exposes the planning as iCalendar feeds at /<token>/events.ics and /<token>/projects.ics,
+// refreshed from the intra every feed_refresh_interval minutes. The server is shut down once ctx is done.
+func serve(ctx context.Context, config *parser.Config) error {
+	events := &feed{name: "events.ics"}
+	projects := &feed{name: "projects.ics"}
+	address := config.FeedAddress
+	interval := time.Duration(config.FeedRefreshInterval) * time.Minute
+
+	if address == "" {
+		address = defaultFeedAddress
+	}
+	if interval <= 0 {
+		interval = defaultRefreshInterval * time.Minute
+	}
+	token, err := getFeedToken(config)
+	if err != nil {
+		return fmt.Errorf("unable to get the feed token: %v", err)
+	}
+
+	if err := refreshFeeds(ctx, config, events, projects); err != nil {
+		log.Printf("Unable to fetch the planning: %v\n", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := refreshFeeds(ctx, config, events, projects); err != nil {
+				log.Printf("Unable to refresh the planning, serving the previous one: %v\n", err)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/"+token+"/events.ics", events)
+	mux.Handle("/"+token+"/projects.ics", projects)
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	source := "the feed_token of the config file"
+	if strings.TrimSpace(config.FeedToken) == "" {
+		source = "in " + config.DataFile(feedTokenFile)
+	}
+	log.Printf("Serving the feeds on %s at /<token>/events.ics and /<token>/projects.ics, the token is %s\n", address, source)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nheuillet/calendar-linker/parser"
+)
+
+func TestFeedNotModified(t *testing.T) {
+	f := &feed{name: "events.ics"}
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/token/events.ics", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d before the first fetch, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	f.update([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"), "0123")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest("GET", "/token/events.ics", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"0123"` || w.Body.Len() == 0 {
+		t.Fatalf("got status %d with the etag %s, want the feed", w.Code, etag)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		hash        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"same data rendered again", "BEGIN:VCALENDAR\r\nDTSTAMP:later\r\nEND:VCALENDAR\r\n", "0123", etag, http.StatusNotModified},
+		{"other etag", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n", "0123", `"4567"`, http.StatusOK},
+		{"data changed", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", "4567", etag, http.StatusOK},
+	}
+	for _, test := range tests {
+		f.update([]byte(test.content), test.hash)
+		r := httptest.NewRequest("GET", "/token/events.ics", nil)
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: the feed was sent with the 304", test.name)
+		}
+	}
+}
+
+func TestFeedTokenIsNeverEmpty(t *testing.T) {
+	defer os.Setenv("XDG_DATA_HOME", os.Getenv("XDG_DATA_HOME"))
+	dir := t.TempDir()
+	os.Setenv("XDG_DATA_HOME", dir)
+	path := filepath.Join(dir, parser.AppName, feedTokenFile)
+
+	config := &parser.Config{FeedToken: " secret\n"}
+	if token, err := getFeedToken(config); err != nil || token != "secret" {
+		t.Fatalf("got %q, %v, want the token of the config", token, err)
+	}
+
+	config.FeedToken = "  "
+	generated, err := getFeedToken(config)
+	if err != nil || len(generated) != 48 {
+		t.Fatalf("got %q, %v, want a generated token", generated, err)
+	}
+	if token, err := getFeedToken(config); err != nil || token != generated {
+		t.Fatalf("got %q, %v, want the saved token %q", token, err, generated)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := getFeedToken(config)
+	if err != nil || len(token) != 48 || token == generated {
+		t.Fatalf("got %q, %v, want a new token replacing the empty file", token, err)
+	}
+	if saved, _ := ioutil.ReadFile(path); string(saved) != token+"\n" {
+		t.Errorf("saved %q, want the new token", saved)
+	}
+}